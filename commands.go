@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lucasb-eyer/go-colorful"
+
+	"github.com/zzwx/gamutmask/lib"
+)
+
+// commands are invoked as the first argument, like "gamutmask merge ..."
+var commands = map[string]func(args []string) error{
+	"collect": runCollect,
+	"merge":   runMerge,
+	"locate":  runLocate,
+	"palette": runPalette,
+}
+
+// runCollect projects all the images passed as arguments into one accumulator file
+func runCollect(args []string) error {
+	flags := flag.NewFlagSet("collect", flag.ExitOnError)
+	var output string
+	flags.StringVar(&output, "output", "", "File name of the resulting accumulator (.gamut)")
+	optionFlags := newOptionFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s collect [flags] images...:\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if output == "" || flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	options, err := optionFlags.options()
+	if err != nil {
+		return err
+	}
+	accumulator, err := lib.NewGamutAccumulatorWithOptions(options)
+	if err != nil {
+		return err
+	}
+	for _, inputFileName := range flags.Args() {
+		img, _, err := lib.DecodeFile(inputFileName)
+		if err != nil {
+			return fmt.Errorf("%v: %w", inputFileName, err)
+		}
+		fmt.Printf("Collecting: %v\n", inputFileName)
+		accumulator.Add(img)
+	}
+	return lib.SaveGamutAccumulator(output, accumulator)
+}
+
+// runMerge merges accumulator files into one and optionally renders it
+func runMerge(args []string) error {
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	var output string
+	flags.StringVar(&output, "output", "", "File name of the merged accumulator (.gamut)")
+	var outputPNG string
+	flags.StringVar(&outputPNG, "png", "", "File name of the gamut image rendered from the merged accumulator")
+	optionFlags := newOptionFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s merge [flags] accumulators...:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "The size, padding and space of the accumulators are kept unless passed\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if (output == "" && outputPNG == "") || flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	options, err := optionFlags.options()
+	if err != nil {
+		return err
+	}
+	accumulator, err := lib.MergeGamutAccumulatorFiles(flags.Args()...)
+	if err != nil {
+		return err
+	}
+	// Flags that haven't been passed take the values of the accumulators
+	passed := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { passed[f.Name] = true })
+	merged := accumulator.Options()
+	if passed["space"] || passed["maxChroma"] || passed["ryb"] {
+		want, _ := lib.NameOfProjection(options.Projection)
+		got, _ := lib.NameOfProjection(merged.Projection)
+		if want != got {
+			return fmt.Errorf("%w: the accumulators are of the %s space, not %s", lib.ErrIncompatibleAccumulator, got, want)
+		}
+	}
+	options.Projection = merged.Projection
+	for _, size := range []struct {
+		name   string
+		value  *int
+		merged int
+	}{
+		{"width", &options.Width, merged.Width},
+		{"height", &options.Height, merged.Height},
+		{"paddingX", &options.PaddingX, merged.PaddingX},
+		{"paddingY", &options.PaddingY, merged.PaddingY},
+	} {
+		if !passed[size.name] {
+			*size.value = size.merged
+		}
+	}
+	if options.Width != merged.Width || options.Height != merged.Height ||
+		options.PaddingX != merged.PaddingX || options.PaddingY != merged.PaddingY {
+		if accumulator, err = accumulator.Resample(options); err != nil {
+			return err
+		}
+	}
+	if output != "" {
+		if err := lib.SaveGamutAccumulator(output, accumulator); err != nil {
+			return err
+		}
+	}
+	if outputPNG != "" {
+		out, err := os.Create(outputPNG)
+		if err != nil {
+			return fmt.Errorf("error creating output file: %w", err)
+		}
+		defer out.Close()
+		if err := png.Encode(out, accumulator.RenderWith(options.Renderer)); err != nil {
+			return fmt.Errorf("error encoding output file: %w", err)
+		}
+	}
+	return nil
+}
+
+// runLocate highlights the pixels of an image that land in a region of its gamut mask
+func runLocate(args []string) error {
+	flags := flag.NewFlagSet("locate", flag.ExitOnError)
+	var input, output string
+	flags.StringVar(&input, "input", "", "File name of the image to look into")
+	flags.StringVar(&output, "output", "", "File name of the resulting PNG image with the pixels of the region highlighted")
+	var circle, polygon, hue, saturation string
+	flags.StringVar(&circle, "circle", "", "Region of the gamut image as a circle: \"x,y,radius\"")
+	flags.StringVar(&polygon, "polygon", "", "Region of the gamut image as a polygon: \"x1,y1 x2,y2 x3,y3...\"")
+	flags.StringVar(&hue, "hue", "", "Region of the wheel as a range of hues in degrees going clockwise: \"min,max\"")
+	flags.StringVar(&saturation, "saturation", "0,1", "Range of saturations from 0 to 1 of the region given by -hue: \"min,max\"")
+	optionFlags := newOptionFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s locate [flags]:\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if input == "" || output == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var region lib.Region
+	switch {
+	case circle != "":
+		values, err := parseFloats(circle, ",", 3)
+		if err != nil {
+			return fmt.Errorf("invalid circle: %w", err)
+		}
+		region = lib.Circle{X: values[0], Y: values[1], Radius: values[2]}
+	case polygon != "":
+		var vertices lib.Polygon
+		for _, vertex := range strings.Fields(polygon) {
+			values, err := parseFloats(vertex, ",", 2)
+			if err != nil {
+				return fmt.Errorf("invalid polygon: %w", err)
+			}
+			vertices = append(vertices, image.Point{int(values[0]), int(values[1])})
+		}
+		if len(vertices) < 3 {
+			return errors.New("invalid polygon: at least 3 vertices expected")
+		}
+		region = vertices
+	case hue != "":
+		hues, err := parseFloats(hue, ",", 2)
+		if err != nil {
+			return fmt.Errorf("invalid hue: %w", err)
+		}
+		saturations, err := parseFloats(saturation, ",", 2)
+		if err != nil {
+			return fmt.Errorf("invalid saturation: %w", err)
+		}
+		region = lib.HueSaturationRange{
+			MinHue: hues[0], MaxHue: hues[1],
+			MinSaturation: saturations[0], MaxSaturation: saturations[1],
+		}
+	default:
+		return errors.New("one of -circle, -polygon or -hue is expected")
+	}
+
+	options, err := optionFlags.options()
+	if err != nil {
+		return err
+	}
+	img, _, err := lib.DecodeFile(input)
+	if err != nil {
+		return err
+	}
+	located, matched, err := lib.Locate(img, region, options)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("error creating output file: %w", err)
+	}
+	defer out.Close()
+	if err := png.Encode(out, located); err != nil {
+		return fmt.Errorf("error encoding output file: %w", err)
+	}
+	fmt.Printf("Located: %v of %vpx\n", comma(strconv.Itoa(matched)),
+		comma(strconv.Itoa(img.Bounds().Dx()*img.Bounds().Dy())))
+	return nil
+}
+
+// runPalette generates a gamut image of a list of colors
+func runPalette(args []string) error {
+	flags := flag.NewFlagSet("palette", flag.ExitOnError)
+	var output string
+	flags.StringVar(&output, "output", "", "File name of the resulting PNG image")
+	var markerRadius float64
+	flags.Float64Var(&markerRadius, "marker", 0, "Radius of the marker of the heaviest color (0 for a 50th of the image size)")
+	optionFlags := newOptionFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s palette [flags] colors...:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Colors are hex triplets with optional weights, like \"#ff8000\" or \"#ff8000:2.5\"\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if output == "" || flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var colors []color.Color
+	var weights []float64
+	for _, arg := range flags.Args() {
+		hex, weight := arg, 1.0
+		if i := strings.LastIndex(arg, ":"); i >= 0 {
+			value, err := strconv.ParseFloat(arg[i+1:], 64)
+			if err != nil {
+				return fmt.Errorf("invalid weight of %q: %w", arg, err)
+			}
+			hex, weight = arg[:i], value
+		}
+		if !strings.HasPrefix(hex, "#") {
+			hex = "#" + hex
+		}
+		c, err := colorful.Hex(hex)
+		if err != nil {
+			return fmt.Errorf("invalid color %q: %w", arg, err)
+		}
+		colors = append(colors, c)
+		weights = append(weights, weight)
+	}
+
+	options, err := optionFlags.options()
+	if err != nil {
+		return err
+	}
+	options.MarkerRadius = markerRadius
+	wheel, err := lib.GenerateGamutMaskFromColors(colors, weights, options)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("error creating output file: %w", err)
+	}
+	defer out.Close()
+	if err := png.Encode(out, wheel); err != nil {
+		return fmt.Errorf("error encoding output file: %w", err)
+	}
+	return nil
+}
+
+// parseFloats parses exactly n floats separated by sep
+func parseFloats(s string, sep string, n int) ([]float64, error) {
+	parts := strings.Split(s, sep)
+	if len(parts) != n {
+		return nil, fmt.Errorf("%d values expected in %q", n, s)
+	}
+	values := make([]float64, n)
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/zzwx/gamutmask/lib"
+)
+
+// optionFlags keep the values of the flags describing lib.Options,
+// shared by every command generating gamut masks
+type optionFlags struct {
+	width     int
+	height    int
+	paddingX  int
+	paddingY  int
+	space     string
+	maxChroma float64
+	ryb       bool
+	munsell   string
+	render    string
+	workers   int
+	sample    string
+
+	// renotation is the Munsell renotation data read from the -munsell file, or the bundled one for the munsell space
+	renotation *lib.MunsellRenotation
+}
+
+// newOptionFlags registers the flags describing lib.Options in flags
+func newOptionFlags(flags *flag.FlagSet) *optionFlags {
+	f := &optionFlags{}
+	flags.IntVar(&f.width, "width", lib.DefaultOptions.Width, "Width of the resulting gamut image")
+	flags.IntVar(&f.height, "height", lib.DefaultOptions.Height, "Height of the resulting gamut image")
+	flags.IntVar(&f.paddingX, "paddingX", lib.DefaultOptions.PaddingX, "Horizontal padding of the wheel inside the resulting gamut image")
+	flags.IntVar(&f.paddingY, "paddingY", lib.DefaultOptions.PaddingY, "Vertical padding of the wheel inside the resulting gamut image")
+	flags.StringVar(&f.space, "space", "hsv", "Color space of the wheel, one of: "+strings.Join(lib.ProjectionNames(), ", "))
+	flags.Float64Var(&f.maxChroma, "maxChroma", 0, "Chroma landing on the edge of the lab, oklab and munsell wheels, the same for every image (0 for about the most saturated sRGB color, 134 for lab, 0.33 for oklab and 20 for munsell)")
+	flags.BoolVar(&f.ryb, "ryb", false, "Place hues of hsv, hsl, hsi, hue-saturation and hue-value on the red-yellow-blue wheel of painters, with red opposite green and yellow opposite purple")
+	flags.StringVar(&f.munsell, "munsell", "", "File of the Munsell renotation data (real.dat) to use instead of the bundled one, also writing a summary of the dominant Munsell color of every image in any space")
+	flags.StringVar(&f.render, "render", "brightest", "How the colors landed on the same spot are drawn, one of: "+strings.Join(lib.RendererNames(), ", "))
+	flags.IntVar(&f.workers, "workers", 0, "Amount of goroutines projecting pixels of an image (0 for the amount of CPUs)")
+	flags.StringVar(&f.sample, "sample", "all", "Pixels to project for quick previews: comma-separated stride=N, rate=R (0 to 1), seed=N and max=N samples")
+	return f
+}
+
+// options returns validated lib.Options out of the flag values
+func (f *optionFlags) options() (lib.Options, error) {
+	if f.munsell != "" {
+		renotation, err := lib.LoadMunsellRenotation(f.munsell)
+		if err != nil {
+			return lib.Options{}, err
+		}
+		f.renotation = renotation
+		lib.RegisterProjection("munsell", lib.MunsellWheel{Renotation: renotation, MaxChroma: lib.DefaultMunsellMaxChroma})
+	}
+	projection, err := f.projection(f.space)
+	if err != nil {
+		return lib.Options{}, err
+	}
+	if name := f.spaceName(); name != f.space {
+		// Registering the modified projection, so accumulators collected with it can be serialized and read back
+		lib.RegisterProjection(name, projection)
+	}
+	renderer, err := lib.RendererByName(f.render)
+	if err != nil {
+		return lib.Options{}, err
+	}
+	sampling, err := lib.ParseSampling(f.sample)
+	if err != nil {
+		return lib.Options{}, err
+	}
+	opts := lib.Options{
+		Width:      f.width,
+		Height:     f.height,
+		PaddingX:   f.paddingX,
+		PaddingY:   f.paddingY,
+		Projection: projection,
+		Renderer:   renderer,
+		Workers:    f.workers,
+		Sampling:   sampling,
+	}
+	return opts, opts.Validate()
+}
+
+// projection returns the projection registered under space with -maxChroma and -ryb applied,
+// the same way for -space and every space of -stack. Call options first, so -munsell is loaded.
+func (f *optionFlags) projection(space string) (lib.Projection, error) {
+	if f.munsell == "" && strings.EqualFold(space, "munsell") {
+		if f.renotation = lib.DefaultMunsellRenotation(); f.renotation == nil {
+			return nil, fmt.Errorf("the munsell space needs the renotation data, see -munsell")
+		}
+	}
+	projection, err := lib.ProjectionByName(space)
+	if err != nil {
+		return nil, err
+	}
+	if f.maxChroma != 0 {
+		switch p := projection.(type) {
+		case lib.ChromaPlane:
+			p.MaxChroma = f.maxChroma
+			projection = p
+		case lib.MunsellWheel:
+			p.MaxChroma = f.maxChroma
+			projection = p
+		default:
+			return nil, fmt.Errorf("-maxChroma can't be used with the %s space", space)
+		}
+	}
+	if f.ryb {
+		switch p := projection.(type) {
+		case lib.ColorWheel:
+			p.HueMap = lib.RYBHue
+			projection = p
+		case lib.HueRectangle:
+			p.HueMap = lib.RYBHue
+			projection = p
+		default:
+			return nil, fmt.Errorf("-ryb can't be used with the %s space", space)
+		}
+	}
+	return projection, nil
+}
+
+// spaceName names the projection for file names, including the chroma it is scaled to if not the default one
+// and the hue mapping
+func (f *optionFlags) spaceName() string {
+	name := f.space
+	if f.maxChroma != 0 {
+		name += "-" + strconv.FormatFloat(f.maxChroma, 'g', -1, 64)
+	}
+	if f.ryb {
+		name += "-ryb"
+	}
+	return name
+}
@@ -3,6 +3,7 @@ module github.com/zzwx/gamutmask
 go 1.13
 
 require (
+	github.com/cespare/xxhash/v2 v2.1.2
 	github.com/fatih/color v1.7.0 // indirect
 	github.com/fogleman/gg v1.3.0
 	github.com/fsnotify/fsnotify v1.4.7
@@ -10,7 +11,7 @@ require (
 	github.com/lucasb-eyer/go-colorful v1.0.2
 	github.com/mattn/go-colorable v0.1.2 // indirect
 	github.com/mattn/go-runewidth v0.0.6 // indirect
-	golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8 // indirect
+	golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8
 	golang.org/x/sys v0.0.0-20191105231009-c1f44814a5cd // indirect
 	gopkg.in/cheggaaa/pb.v1 v1.0.28
 )
@@ -0,0 +1,235 @@
+package lib
+
+import (
+	"context"
+	"image"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fogleman/gg"
+)
+
+// GamutAccumulator collects samples of any number of images projected onto a canvas
+// of a fixed size into a Grid of Bins. The wheel can be rendered out of the grid
+// at any moment, any number of times and with any Renderer.
+//
+// The result doesn't depend on the order images (or parts of them) are added in.
+type GamutAccumulator struct {
+	opts       Options
+	projection Projection
+	grid       *Grid
+	colors     *ColorCounts // Only counted after CountColors
+}
+
+// NewGamutAccumulator creates an empty accumulator for a wheel of maskWidth by maskHeight.
+// Use NewGamutAccumulatorWithOptions to have the sizes validated.
+func NewGamutAccumulator(maskWidth, maskHeight, paddingX, paddingY int) *GamutAccumulator {
+	return newGamutAccumulator(Options{
+		Width:    maskWidth,
+		Height:   maskHeight,
+		PaddingX: paddingX,
+		PaddingY: paddingY,
+	})
+}
+
+// NewGamutAccumulatorWithOptions creates an empty accumulator for a wheel described by opts.
+// Returns an *OptionsError if opts are invalid.
+func NewGamutAccumulatorWithOptions(opts Options) (*GamutAccumulator, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return newGamutAccumulator(opts), nil
+}
+
+func newGamutAccumulator(opts Options) *GamutAccumulator {
+	return &GamutAccumulator{
+		opts:       opts,
+		projection: opts.projection(),
+		grid:       NewGrid(opts.Width, opts.Height),
+	}
+}
+
+// Options returns the options the accumulator has been created with
+func (a *GamutAccumulator) Options() Options {
+	return a.opts
+}
+
+// CountColors makes the accumulator count the samples of every exact color added from now on, besides
+// projecting them, for summaries like MunsellRenotation.Summarize. It takes a map update for every change
+// of color between pixels, so it is off by default.
+func (a *GamutAccumulator) CountColors() {
+	if a.colors == nil {
+		a.colors = NewColorCounts()
+	}
+}
+
+// Colors returns the colors counted since CountColors has been called, or nil. Counts are kept in memory only:
+// they are neither serialized nor resampled.
+func (a *GamutAccumulator) Colors() *ColorCounts {
+	return a.colors
+}
+
+// tileRows is the height of the tiles (strips of rows of the source image) AddContext splits images into.
+// It is also how often AddContext checks for cancellation and reports progress.
+const tileRows = 16
+
+// Add projects every pixel of img (or the ones picked by Options.Sampling) onto the wheel
+func (a *GamutAccumulator) Add(img image.Image) {
+	a.AddContext(context.Background(), img, nil)
+}
+
+// AddContext projects every pixel of img (or the ones picked by Options.Sampling) onto the wheel, reporting the portion of img done
+// (from 0 to 1) to the optional progress function every few rows.
+//
+// The image is split into tiles projected concurrently by Options.Workers goroutines,
+// each one into a grid of its own. The grids are merged into the accumulator at the end,
+// giving the same result as projecting the image on a single goroutine.
+//
+// It stops with ctx.Err() once ctx is done, in which case only a part of img may be added.
+func (a *GamutAccumulator) AddContext(ctx context.Context, img image.Image, progress func(done float64)) error {
+	bounds := img.Bounds()
+	tiles := (bounds.Dy() + tileRows - 1) / tileRows
+	workers := a.opts.workers()
+	if workers > tiles {
+		workers = tiles
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	sampler := newSampler(a.opts.Sampling, bounds)
+	sampled := !a.opts.Sampling.All(bounds)
+
+	grids := make([]*Grid, workers)
+	colors := make([]*ColorCounts, workers)
+	var next int64 = -1
+	var wg sync.WaitGroup
+	rowsDone := make(chan int, workers)
+	for w := range grids {
+		if w == 0 {
+			grids[w], colors[w] = a.grid, a.colors // With a single worker there is nothing to merge
+		} else {
+			grids[w] = NewGrid(a.opts.Width, a.opts.Height)
+			if a.colors != nil {
+				colors[w] = NewColorCounts()
+			}
+		}
+		wg.Add(1)
+		go func(projector *projector) {
+			defer wg.Done()
+			defer projector.flush()
+			for {
+				tile := int(atomic.AddInt64(&next, 1))
+				if tile >= tiles || ctx.Err() != nil {
+					return
+				}
+				rect := image.Rect(bounds.Min.X, bounds.Min.Y+tile*tileRows, bounds.Max.X, bounds.Min.Y+(tile+1)*tileRows)
+				if sampled {
+					projector.addSampled(img, rect.Intersect(bounds), sampler)
+				} else {
+					projector.addRect(img, rect.Intersect(bounds))
+				}
+				rowsDone <- rect.Intersect(bounds).Dy()
+			}
+		}(newProjector(a, grids[w], colors[w]))
+	}
+	go func() {
+		wg.Wait()
+		close(rowsDone)
+	}()
+
+	if progress != nil {
+		progress(0)
+	}
+	rows := 0
+	for n := range rowsDone {
+		rows += n
+		if progress != nil {
+			progress(float64(rows) / float64(bounds.Dy()))
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for w, grid := range grids[1:] {
+		a.grid.Merge(grid)
+		if a.colors != nil {
+			a.colors.Merge(colors[w+1])
+		}
+	}
+	if progress != nil && rows == 0 {
+		progress(1) // Nothing has been reported for an empty image
+	}
+	return nil
+}
+
+func (a *GamutAccumulator) add(r, g, b uint32) {
+	a.addTo(a.grid, r, g, b)
+	if a.colors != nil {
+		a.colors.Add(uint16(r), uint16(g), uint16(b), 1)
+	}
+}
+
+func (a *GamutAccumulator) addTo(grid *Grid, r, g, b uint32) {
+	p, _, _, key, ok := a.opts.place(a.projection, r, g, b)
+	if ok {
+		grid.At(p.X, p.Y).Add(uint16(r), uint16(g), uint16(b), key)
+	}
+}
+
+// Grid returns the bins collected so far. The grid is owned by the accumulator and must not be modified.
+func (a *GamutAccumulator) Grid() *Grid {
+	return a.grid
+}
+
+// Render draws the wheel with all the samples collected so far using the Renderer of the options
+func (a *GamutAccumulator) Render() (wheel *image.RGBA64) {
+	return a.RenderWith(a.opts.renderer())
+}
+
+// RenderWith draws the wheel with all the samples collected so far using renderer.
+// Projections implementing Background and Overlay draw the background and marks over the colors.
+func (a *GamutAccumulator) RenderWith(renderer Renderer) (wheel *image.RGBA64) {
+	width, height := a.opts.Width, a.opts.Height
+	wheel = image.NewRGBA64(image.Rect(0, 0, width, height))
+
+	context := gg.NewContext(width, height)
+	if background, ok := a.projection.(Background); ok {
+		background.DrawBackground(context, a.opts)
+	} else {
+		context.DrawEllipse(float64(width)/2, float64(height)/2, float64(width)/2, float64(height)/2)
+		context.SetRGB(0, 0, 0)
+		context.Fill()
+	}
+	copyRGBA(wheel, context.Image().(*image.RGBA))
+
+	renderer.Render(a.grid, wheel)
+	if overlay, ok := a.projection.(Overlay); ok {
+		drawOver(wheel, func(context *gg.Context) {
+			overlay.DrawOverlay(context, a.opts)
+		})
+	}
+	return wheel
+}
+
+// Resample returns a new accumulator for opts with all the samples collected by a, placing every bin
+// where its color with the highest key lands with opts.Projection, which has to be the projection the
+// samples have been collected with. Resampling from a larger canvas closely approximates adding the images
+// again: only the colors sharing a bin with a brighter one may end up a pixel away.
+func (a *GamutAccumulator) Resample(opts Options) (*GamutAccumulator, error) {
+	resampled, err := NewGamutAccumulatorWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range a.grid.Bins {
+		bin := &a.grid.Bins[i]
+		if bin.Count == 0 {
+			continue
+		}
+		p, _, _, _, ok := opts.place(resampled.projection, uint32(bin.R), uint32(bin.G), uint32(bin.B))
+		if ok {
+			resampled.grid.At(p.X, p.Y).Merge(bin)
+		}
+	}
+	return resampled, nil
+}
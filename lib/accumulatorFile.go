@@ -0,0 +1,238 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// accumulatorMagic starts every serialized GamutAccumulator
+const accumulatorMagic = "GMSK"
+
+// accumulatorVersion is bumped every time the layout of the serialized accumulator changes
+const accumulatorVersion uint16 = 1
+
+var (
+	// ErrUnsupportedFormat is returned when reading data that is not a serialized GamutAccumulator
+	// or has been written by an unknown version
+	ErrUnsupportedFormat = errors.New("unsupported gamut accumulator format")
+	// ErrIncompatibleAccumulator is returned when merging accumulators of different geometry or projection
+	ErrIncompatibleAccumulator = errors.New("incompatible gamut accumulator")
+)
+
+// accumulatorHeader is written once in front of the bins, followed by the length (as uint16)
+// and the name of the projection
+type accumulatorHeader struct {
+	Version  uint16
+	Width    uint32
+	Height   uint32
+	PaddingX uint32
+	PaddingY uint32
+	Bins     uint32 // Amount of non-empty bins that follow
+}
+
+// accumulatorBin is a non-empty bin as it is serialized. Empty bins are not written at all.
+type accumulatorBin struct {
+	Index            uint32
+	Count            uint64
+	SumR, SumG, SumB uint64
+	MinKey, MaxKey   float64
+	R, G, B          uint16
+}
+
+// WriteTo serializes the accumulated bins into w along with the name the projection is registered under
+// (see RegisterProjection), failing with ErrUnknownProjection for projections that aren't registered.
+// Only non-empty bins are written, so the size depends on how much of the wheel is covered rather than on its size.
+func (a *GamutAccumulator) WriteTo(w io.Writer) (n int64, err error) {
+	name, ok := NameOfProjection(a.projection)
+	if !ok {
+		return 0, fmt.Errorf("can't write gamut accumulator: %w", ErrUnknownProjection)
+	}
+	bw := bufio.NewWriter(w)
+	counter := &countingWriter{w: bw}
+
+	header := accumulatorHeader{
+		Version:  accumulatorVersion,
+		Width:    uint32(a.opts.Width),
+		Height:   uint32(a.opts.Height),
+		PaddingX: uint32(a.opts.PaddingX),
+		PaddingY: uint32(a.opts.PaddingY),
+	}
+	for i := range a.grid.Bins {
+		if a.grid.Bins[i].Count > 0 {
+			header.Bins++
+		}
+	}
+	if _, err := io.WriteString(counter, accumulatorMagic); err != nil {
+		return counter.n, err
+	}
+	if err := binary.Write(counter, binary.LittleEndian, &header); err != nil {
+		return counter.n, err
+	}
+	if err := binary.Write(counter, binary.LittleEndian, uint16(len(name))); err != nil {
+		return counter.n, err
+	}
+	if _, err := io.WriteString(counter, name); err != nil {
+		return counter.n, err
+	}
+	for i := range a.grid.Bins {
+		current := &a.grid.Bins[i]
+		if current.Count > 0 {
+			if err := binary.Write(counter, binary.LittleEndian, &accumulatorBin{
+				Index: uint32(i),
+				Count: current.Count,
+				SumR:  current.SumR, SumG: current.SumG, SumB: current.SumB,
+				MinKey: current.MinKey, MaxKey: current.MaxKey,
+				R: current.R, G: current.G, B: current.B,
+			}); err != nil {
+				return counter.n, err
+			}
+		}
+	}
+	return counter.n, bw.Flush()
+}
+
+// ReadGamutAccumulator restores an accumulator previously serialized with WriteTo, with the projection
+// registered under the serialized name.
+func ReadGamutAccumulator(r io.Reader) (*GamutAccumulator, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(accumulatorMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, fmt.Errorf("can't read gamut accumulator: %w", err)
+	}
+	if string(magic) != accumulatorMagic {
+		return nil, ErrUnsupportedFormat
+	}
+	var header accumulatorHeader
+	if err := binary.Read(br, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("can't read gamut accumulator header: %w", err)
+	}
+	if header.Version != accumulatorVersion {
+		return nil, fmt.Errorf("%w: version %d", ErrUnsupportedFormat, header.Version)
+	}
+	var length uint16
+	if err := binary.Read(br, binary.LittleEndian, &length); err != nil {
+		return nil, fmt.Errorf("can't read gamut accumulator header: %w", err)
+	}
+	name := make([]byte, length)
+	if _, err := io.ReadFull(br, name); err != nil {
+		return nil, fmt.Errorf("can't read gamut accumulator header: %w", err)
+	}
+	projection, err := ProjectionByName(string(name))
+	if err != nil {
+		return nil, fmt.Errorf("can't read gamut accumulator: %w", err)
+	}
+	if uint64(header.Width)*uint64(header.Height) > math.MaxInt32 {
+		return nil, fmt.Errorf("%w: size %dx%d", ErrUnsupportedFormat, header.Width, header.Height)
+	}
+
+	a, err := NewGamutAccumulatorWithOptions(Options{
+		Width:      int(header.Width),
+		Height:     int(header.Height),
+		PaddingX:   int(header.PaddingX),
+		PaddingY:   int(header.PaddingY),
+		Projection: projection,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	for i := uint32(0); i < header.Bins; i++ {
+		var current accumulatorBin
+		if err := binary.Read(br, binary.LittleEndian, &current); err != nil {
+			return nil, fmt.Errorf("can't read gamut accumulator bin: %w", err)
+		}
+		if int(current.Index) >= len(a.grid.Bins) {
+			return nil, fmt.Errorf("%w: bin %d out of range", ErrUnsupportedFormat, current.Index)
+		}
+		a.grid.Bins[current.Index] = Bin{
+			Count: current.Count,
+			SumR:  current.SumR, SumG: current.SumG, SumB: current.SumB,
+			MinKey: current.MinKey, MaxKey: current.MaxKey,
+			R: current.R, G: current.G, B: current.B,
+		}
+	}
+	return a, nil
+}
+
+// Merge adds all the samples collected by other into a. Both have to be of the same size, padding and projection.
+// The result is the same as if all the images added to other were added to a. Colors are only kept counted
+// (see CountColors) if both count them.
+func (a *GamutAccumulator) Merge(other *GamutAccumulator) error {
+	if !a.opts.sameGeometry(other.opts) {
+		return fmt.Errorf("%w: %dx%d (padding %d, %d) and %dx%d (padding %d, %d)", ErrIncompatibleAccumulator,
+			a.opts.Width, a.opts.Height, a.opts.PaddingX, a.opts.PaddingY,
+			other.opts.Width, other.opts.Height, other.opts.PaddingX, other.opts.PaddingY)
+	}
+	if !sameProjection(a.projection, other.projection) {
+		return fmt.Errorf("%w: projections %v and %v", ErrIncompatibleAccumulator,
+			projectionName(a.projection), projectionName(other.projection))
+	}
+	a.grid.Merge(other.grid)
+	if a.colors != nil && other.colors != nil {
+		a.colors.Merge(other.colors)
+	} else {
+		a.colors = nil
+	}
+	return nil
+}
+
+// SaveGamutAccumulator writes the accumulator into fileName, replacing it if it exists
+func SaveGamutAccumulator(fileName string, a *GamutAccumulator) error {
+	out, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("can't create gamut accumulator file: %w", err)
+	}
+	if _, err := a.WriteTo(out); err != nil {
+		out.Close()
+		return fmt.Errorf("can't write gamut accumulator file: %w", err)
+	}
+	return out.Close()
+}
+
+// LoadGamutAccumulator reads the accumulator saved with SaveGamutAccumulator
+func LoadGamutAccumulator(fileName string) (*GamutAccumulator, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("can't open gamut accumulator file: %w", err)
+	}
+	defer file.Close()
+	return ReadGamutAccumulator(file)
+}
+
+// MergeGamutAccumulatorFiles loads all the fileNames and merges them into one accumulator
+func MergeGamutAccumulatorFiles(fileNames ...string) (*GamutAccumulator, error) {
+	var result *GamutAccumulator
+	for _, fileName := range fileNames {
+		a, err := LoadGamutAccumulator(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", fileName, err)
+		}
+		if result == nil {
+			result = a
+			continue
+		}
+		if err := result.Merge(a); err != nil {
+			return nil, fmt.Errorf("%v: %w", fileName, err)
+		}
+	}
+	if result == nil {
+		return nil, errors.New("no gamut accumulator files to merge")
+	}
+	return result, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
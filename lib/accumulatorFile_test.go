@@ -0,0 +1,120 @@
+package lib_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/zzwx/gamutmask/lib"
+	"github.com/zzwx/gamutmask/lib/gamuttest"
+)
+
+// writeAccumulator serializes a
+func writeAccumulator(t *testing.T, a *lib.GamutAccumulator) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	n, err := a.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("got %d bytes reported, %d written", n, buf.Len())
+	}
+	return buf.Bytes()
+}
+
+func TestWriteToReadGamutAccumulator(t *testing.T) {
+	accumulator := lib.NewGamutAccumulator(250, 250, 2, 2)
+	accumulator.Add(gamuttest.HSVSweep(200, 100, 0.7))
+	read, err := lib.ReadGamutAccumulator(bytes.NewReader(writeAccumulator(t, accumulator)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(accumulator.Grid(), read.Grid()) {
+		t.Error("bins differ")
+	}
+}
+
+func TestWriteToReadGamutAccumulatorProjection(t *testing.T) {
+	opts := lib.DefaultOptions
+	opts.Projection = lib.CIELAB
+	accumulator := accumulate(t, opts, gamuttest.Photo(200, 100))
+	read, err := lib.ReadGamutAccumulator(bytes.NewReader(writeAccumulator(t, accumulator)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Images added to the restored accumulator are projected the same way
+	more := gamuttest.HSVSweep(120, 60, 0.9)
+	accumulator.Add(more)
+	read.Add(more)
+	expectSameBins(t, accumulator, read)
+
+	opts.Projection = unregistered{}
+	if _, err := accumulate(t, opts).WriteTo(ioutil.Discard); !errors.Is(err, lib.ErrUnknownProjection) {
+		t.Errorf("got %v, want %v", err, lib.ErrUnknownProjection)
+	}
+}
+
+// unregistered is a projection never registered under a name
+type unregistered struct{}
+
+func (unregistered) Project(r, g, b uint32) (x, y, key float64) {
+	return 0, 0, 0
+}
+
+func TestMerge(t *testing.T) {
+	first, second := gamuttest.HSVSweep(200, 100, 0.5), gamuttest.HSVSweep(120, 60, 0.9)
+	both := lib.NewGamutAccumulator(250, 250, 2, 2)
+	both.Add(first)
+	both.Add(second)
+
+	// Merging accumulators read back, as the merge command does
+	var accumulators []*lib.GamutAccumulator
+	for _, img := range []image.Image{first, second} {
+		accumulator := lib.NewGamutAccumulator(250, 250, 2, 2)
+		accumulator.Add(img)
+		read, err := lib.ReadGamutAccumulator(bytes.NewReader(writeAccumulator(t, accumulator)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		accumulators = append(accumulators, read)
+	}
+	if err := accumulators[0].Merge(accumulators[1]); err != nil {
+		t.Fatal(err)
+	}
+	expectSameWheels(t, both.Render(), accumulators[0].Render())
+}
+
+func TestMergeIncompatible(t *testing.T) {
+	accumulator := lib.NewGamutAccumulator(250, 250, 2, 2)
+	for _, other := range []*lib.GamutAccumulator{
+		lib.NewGamutAccumulator(300, 250, 2, 2),
+		lib.NewGamutAccumulator(250, 250, 2, 4),
+		accumulate(t, lib.Options{Width: 250, Height: 250, PaddingX: 2, PaddingY: 2, Projection: lib.CIELAB}),
+	} {
+		if err := accumulator.Merge(other); !errors.Is(err, lib.ErrIncompatibleAccumulator) {
+			t.Errorf("got %v, want %v", err, lib.ErrIncompatibleAccumulator)
+		}
+	}
+}
+
+func TestReadGamutAccumulatorUnsupported(t *testing.T) {
+	accumulator := lib.NewGamutAccumulator(250, 250, 2, 2)
+	accumulator.Add(gamuttest.HSVSweep(20, 10, 0.7))
+	data := writeAccumulator(t, accumulator)
+	newer := append([]byte{}, data...)
+	newer[4] = 0xFF // The version
+	older := append([]byte{}, data...)
+	older[4] = 0
+	for _, data := range [][]byte{[]byte("PNG not an accumulator"), newer, older} {
+		if _, err := lib.ReadGamutAccumulator(bytes.NewReader(data)); !errors.Is(err, lib.ErrUnsupportedFormat) {
+			t.Errorf("got %v, want %v", err, lib.ErrUnsupportedFormat)
+		}
+	}
+	if _, err := lib.ReadGamutAccumulator(bytes.NewReader(data[:len(data)-3])); err == nil {
+		t.Error("truncated accumulator read")
+	}
+}
@@ -0,0 +1,161 @@
+package lib_test
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"github.com/zzwx/gamutmask/lib"
+	"github.com/zzwx/gamutmask/lib/gamuttest"
+)
+
+// expectSameWheels fails t unless both wheels have the same size and colors
+func expectSameWheels(t *testing.T, a, b image.Image) {
+	t.Helper()
+	differing, first, err := gamuttest.Diff(a, b, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if differing > 0 {
+		t.Errorf("%d pixels differ, first one at %v", differing, first)
+	}
+}
+
+// TestGamutAccumulatorOrder checks the wheel doesn't depend on the order images are added in
+func TestGamutAccumulatorOrder(t *testing.T) {
+	dark, bright := gamuttest.HSVSweep(120, 60, 0.4), gamuttest.HSVSweep(90, 40, 0.9)
+	both := image.NewNRGBA64(image.Rect(0, 0, 120, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 120; x++ {
+			if y < 60 {
+				both.Set(x, y, dark.At(x, y))
+			} else if x < 90 {
+				both.Set(x, y, bright.At(x, y-60))
+			}
+		}
+	}
+	want := lib.GenerateGamutMask(both, 250, 250, 2, 2)
+
+	darkFirst := lib.NewGamutAccumulator(250, 250, 2, 2)
+	darkFirst.Add(dark)
+	darkFirst.Add(bright)
+	darkFirst.Add(image.NewNRGBA(image.Rect(0, 0, 30, 40))) // The black padding of both
+	brightFirst := lib.NewGamutAccumulator(250, 250, 2, 2)
+	brightFirst.Add(bright.SubImage(image.Rect(0, 20, 90, 40)))
+	brightFirst.Add(dark)
+	brightFirst.Add(bright.SubImage(image.Rect(0, 0, 90, 20)))
+	brightFirst.Add(image.NewNRGBA(image.Rect(0, 0, 30, 40)))
+
+	expectSameWheels(t, want, darkFirst.Render())
+	expectSameWheels(t, want, brightFirst.Render())
+	expectSameWheels(t, want, brightFirst.Render()) // Rendering again
+}
+
+// TestGamutAccumulatorBrightest checks the brightest of the colors landing on the same spot is drawn
+func TestGamutAccumulatorBrightest(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	img.Set(0, 0, color.NRGBA{100, 50, 50, 255})
+	img.Set(1, 0, color.NRGBA{200, 100, 100, 255}) // The same hue and saturation, brighter
+	img.Set(2, 0, color.NRGBA{150, 75, 75, 255})
+	wheel := lib.GenerateGamutMask(img, 250, 250, 2, 2)
+	found := 0
+	for y := 0; y < 250; y++ {
+		for x := 0; x < 250; x++ {
+			c := wheel.RGBA64At(x, y)
+			if c.R == 0 && c.G == 0 && c.B == 0 { // The background
+				continue
+			}
+			if got := color.NRGBAModel.Convert(c); got != (color.NRGBA{200, 100, 100, 255}) {
+				t.Errorf("got %v drawn, want the brightest color", got)
+			}
+			found++
+		}
+	}
+	if found != 1 {
+		t.Errorf("got %d colors drawn, want 1", found)
+	}
+}
+
+func TestAddContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	accumulator := lib.NewGamutAccumulator(250, 250, 2, 2)
+	if err := accumulator.AddContext(ctx, gamuttest.HSVSweep(300, 200, 0.6), nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	wheel, err := lib.GenerateGamutMaskContext(ctx, gamuttest.HSVSweep(300, 200, 0.6), lib.DefaultOptions, nil)
+	if !errors.Is(err, context.Canceled) || wheel != nil {
+		t.Errorf("got %v, want %v and no wheel", err, context.Canceled)
+	}
+}
+
+func TestAddContextProgress(t *testing.T) {
+	var reported []float64
+	accumulator := lib.NewGamutAccumulator(250, 250, 2, 2)
+	err := accumulator.AddContext(context.Background(), gamuttest.HSVSweep(30, 100, 0.6), func(done float64) {
+		reported = append(reported, done)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) < 3 || reported[0] != 0 || reported[len(reported)-1] != 1 {
+		t.Fatalf("got %v reported, want from 0 to 1", reported)
+	}
+	for i := 1; i < len(reported); i++ {
+		if reported[i] < reported[i-1] {
+			t.Errorf("got %v reported, want it growing", reported)
+		}
+	}
+}
+
+// TestAddWorkers checks projecting tiles concurrently collects the same bins as projecting on a single goroutine
+// accumulate adds images to a new accumulator with opts
+func accumulate(t testing.TB, opts lib.Options, images ...image.Image) *lib.GamutAccumulator {
+	t.Helper()
+	accumulator, err := lib.NewGamutAccumulatorWithOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, img := range images {
+		accumulator.Add(img)
+	}
+	return accumulator
+}
+
+// expectSameBins fails t unless a and b have collected the same bins
+func expectSameBins(t testing.TB, a, b *lib.GamutAccumulator) {
+	t.Helper()
+	if !reflect.DeepEqual(a.Grid(), b.Grid()) {
+		t.Error("bins differ")
+	}
+}
+
+func TestAddWorkers(t *testing.T) {
+	photo := gamuttest.Photo(300, 200)
+	serialOpts, parallelOpts := lib.DefaultOptions, lib.DefaultOptions
+	serialOpts.Workers, parallelOpts.Workers = 1, 7
+	for _, img := range []image.Image{
+		photo,
+		gamuttest.Opaque(photo),
+		gamuttest.HSVSweep(300, 200, 0.8),
+		gamuttest.HueRamp(100, 90),
+		gamuttest.Grayscale(64, 1), // Less rows than workers
+	} {
+		serial, parallel := accumulate(t, serialOpts), accumulate(t, parallelOpts)
+		serial.CountColors()
+		parallel.CountColors()
+		serial.Add(img)
+		parallel.Add(img)
+
+		expectSameBins(t, serial, parallel)
+		if !reflect.DeepEqual(serial.Colors(), parallel.Colors()) {
+			t.Errorf("%v: colors counted differ", img.Bounds())
+		}
+		if got, want := parallel.Colors().Samples(), uint64(img.Bounds().Dx()*img.Bounds().Dy()); got != want {
+			t.Errorf("got %d samples counted, want %d", got, want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package lib
+
+import (
+	"errors"
+	"math"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// ChromaPlane is a Projection plotting the opponent axes of a perceptual color space, like a* and b* of CIELAB.
+// The distance from the center is the absolute chroma rather than the saturation relative to the value of the color,
+// so dark and bright colors of the same hue land apart and wheels of different images can be compared.
+// Hue goes clockwise like on a ColorWheel, with +a (red) on top and +b (yellow) on the right.
+type ChromaPlane struct {
+	// Model converts a color with 16-bit components into lightness from 0 to 1, deciding which color is shown
+	// on the same spot, and the opponent coordinates a and b
+	Model func(r, g, b uint32) (l, a, bb float64)
+	// MaxChroma is the chroma (in the units of a and b) landing on the edge of the wheel.
+	// Colors with a higher chroma are drawn on the edge.
+	MaxChroma float64
+}
+
+// ErrInvalidMaxChroma is wrapped by an OptionsError when MaxChroma of a ChromaPlane or MunsellWheel is not positive
+var ErrInvalidMaxChroma = errors.New("max chroma must be positive")
+
+// Project implements Projection
+func (p ChromaPlane) Project(r, g, b uint32) (x, y, key float64) {
+	key, a, bb := p.Model(r, g, b)
+	x, y = bb/p.MaxChroma, -a/p.MaxChroma
+	if c := math.Hypot(x, y); c > 1 {
+		x, y = x/c, y/c
+	}
+	return x, y, key
+}
+
+// Validate implements Validator
+func (p ChromaPlane) Validate() error {
+	if !(p.MaxChroma > 0) {
+		return &OptionsError{Field: "MaxChroma", Value: p.MaxChroma, Err: ErrInvalidMaxChroma}
+	}
+	return nil
+}
+
+const (
+	// LabMaxChroma is the default MaxChroma of CIELAB, about the chroma of the sRGB blue
+	LabMaxChroma = 134
+	// OKLabMaxChroma is the default MaxChroma of OKLab, about the chroma of the sRGB magenta
+	OKLabMaxChroma = 0.33
+)
+
+var (
+	// CIELAB is the a*, b* plane of CIELAB (D65) where lighter wins, with the chroma of sRGB colors up to LabMaxChroma
+	CIELAB Projection = ChromaPlane{Model: lab, MaxChroma: LabMaxChroma}
+	// OKLab is the a, b plane of OKLab where lighter wins, with the chroma of sRGB colors up to OKLabMaxChroma
+	OKLab Projection = ChromaPlane{Model: oklab, MaxChroma: OKLabMaxChroma}
+)
+
+// lab returns CIELAB lightness from 0 to 1 along with a* and b* in the usual units
+func lab(r, g, b uint32) (l, a, bb float64) {
+	c := colorful.Color{
+		R: float64(r) / float64(0xFFFF),
+		G: float64(g) / float64(0xFFFF),
+		B: float64(b) / float64(0xFFFF)}
+	l, a, bb = c.Lab() // go-colorful keeps all of them a hundred times smaller
+	return l, a * 100, bb * 100
+}
+
+// oklab converts sRGB into OKLab as defined by Björn Ottosson
+func oklab(r, g, b uint32) (l, a, bb float64) {
+	c := colorful.Color{
+		R: float64(r) / float64(0xFFFF),
+		G: float64(g) / float64(0xFFFF),
+		B: float64(b) / float64(0xFFFF)}
+	lr, lg, lb := c.LinearRgb()
+	lc := math.Cbrt(0.4122214708*lr + 0.5363325363*lg + 0.0514459929*lb)
+	mc := math.Cbrt(0.2119034982*lr + 0.6806995451*lg + 0.1073969566*lb)
+	sc := math.Cbrt(0.0883024619*lr + 0.2817188376*lg + 0.6299787005*lb)
+	l = 0.2104542553*lc + 0.7936177850*mc - 0.0040720468*sc
+	a = 1.9779984951*lc - 2.4285922050*mc + 0.4505937099*sc
+	bb = 0.0259040371*lc + 0.7827717662*mc - 0.8086757660*sc
+	// The rounded coefficients leave grays with a tiny chroma, which would move them off the center
+	if math.Abs(a) < 1e-6 && math.Abs(bb) < 1e-6 {
+		a, bb = 0, 0
+	}
+	return l, a, bb
+}
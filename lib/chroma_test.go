@@ -0,0 +1,37 @@
+package lib_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/zzwx/gamutmask/lib"
+	"github.com/zzwx/gamutmask/lib/gamuttest"
+)
+
+// TestChromaPlanes checks colors land at their a and b coordinates (+a on top, +b on the right)
+// scaled by the max chroma, on the default 250x250 wheel with a radius of 123 pixels
+func TestChromaPlanes(t *testing.T) {
+	for _, test := range []struct {
+		projection lib.Projection
+		color      color.Color
+		want       image.Point
+	}{
+		{lib.CIELAB, color.RGBA{255, 0, 0, 255}, image.Pt(187, 51)},  // a* 80, b* 67
+		{lib.CIELAB, color.RGBA{128, 0, 0, 255}, image.Pt(160, 81)},  // Darker red has less chroma
+		{lib.CIELAB, color.RGBA{0, 0, 255, 255}, image.Pt(26, 52)},   // a* 79, b* -108, about LabMaxChroma
+		{lib.CIELAB, color.RGBA{0, 255, 0, 255}, image.Pt(201, 204)}, // a* -86, b* 83
+		{lib.CIELAB, color.RGBA{90, 90, 90, 255}, image.Pt(125, 125)},
+		{lib.OKLab, color.RGBA{255, 0, 0, 255}, image.Pt(172, 41)},  // a 0.225, b 0.126
+		{lib.OKLab, color.RGBA{0, 0, 255, 255}, image.Pt(9, 137)},   // a -0.032, b -0.312
+		{lib.OKLab, color.RGBA{0, 255, 0, 255}, image.Pt(192, 212)}, // a -0.234, b 0.179
+		{lib.OKLab, color.RGBA{90, 90, 90, 255}, image.Pt(125, 125)},
+		{lib.OKLab, color.RGBA{255, 255, 255, 255}, image.Pt(125, 125)},
+		// Chroma over the max one is drawn on the edge
+		{lib.ChromaPlane{Model: lib.CIELAB.(lib.ChromaPlane).Model, MaxChroma: 50}, color.RGBA{0, 255, 0, 255}, image.Pt(210, 213)},
+	} {
+		opts := lib.DefaultOptions
+		opts.Projection = test.projection
+		gamuttest.ExpectLandsAt(t, opts, test.color, test.want, 1)
+	}
+}
@@ -0,0 +1,222 @@
+package lib
+
+import (
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// XY is a point of the CIE 1931 xy chromaticity diagram
+type XY struct {
+	X, Y float64
+}
+
+// Gamut is an RGB color space described by the chromaticities of its primaries and white point
+type Gamut struct {
+	Name                    string
+	Red, Green, Blue, White XY
+	// Linearize turns an encoded component from 0 to 1 into linear light
+	Linearize func(v float64) float64
+}
+
+var (
+	// SRGB is the gamut of sRGB, which colors of images are taken to be in
+	SRGB = Gamut{
+		Name:      "sRGB",
+		Red:       XY{0.64, 0.33},
+		Green:     XY{0.30, 0.60},
+		Blue:      XY{0.15, 0.06},
+		White:     XY{0.3127, 0.3290},
+		Linearize: srgbLinear,
+	}
+	// DisplayP3 is the gamut of Display P3, sharing the white point and transfer function of sRGB
+	DisplayP3 = Gamut{
+		Name:      "Display P3",
+		Red:       XY{0.680, 0.320},
+		Green:     XY{0.265, 0.690},
+		Blue:      XY{0.150, 0.060},
+		White:     XY{0.3127, 0.3290},
+		Linearize: srgbLinear,
+	}
+	// Rec2020 is the gamut of ITU-R BT.2020
+	Rec2020 = Gamut{
+		Name:      "Rec.2020",
+		Red:       XY{0.708, 0.292},
+		Green:     XY{0.170, 0.797},
+		Blue:      XY{0.131, 0.046},
+		White:     XY{0.3127, 0.3290},
+		Linearize: rec2020Linear,
+	}
+)
+
+func srgbLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func rec2020Linear(v float64) float64 {
+	const alpha, beta = 1.09929682680944, 0.018053968510807
+	if v < beta*4.5 {
+		return v / 4.5
+	}
+	return math.Pow((v+alpha-1)/alpha, 1/0.45)
+}
+
+// toXYZ returns the matrix converting linear components of the gamut into CIE XYZ
+func (g Gamut) toXYZ() (m [3][3]float64) {
+	primaries := [3]XY{g.Red, g.Green, g.Blue}
+	for i, p := range primaries {
+		m[0][i], m[1][i], m[2][i] = p.X/p.Y, 1, (1-p.X-p.Y)/p.Y
+	}
+	// Scaling the primaries so that the sum of them is the white point with Y = 1
+	white := [3]float64{g.White.X / g.White.Y, 1, (1 - g.White.X - g.White.Y) / g.White.Y}
+	scale := multiply(invert(m), white)
+	for i := range m {
+		for j := range m[i] {
+			m[i][j] *= scale[j]
+		}
+	}
+	return m
+}
+
+func multiply(m [3][3]float64, v [3]float64) (r [3]float64) {
+	for i := range m {
+		r[i] = m[i][0]*v[0] + m[i][1]*v[1] + m[i][2]*v[2]
+	}
+	return r
+}
+
+func invert(m [3][3]float64) (r [3][3]float64) {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			// Cofactor of the transposed matrix
+			a, b := m[(j+1)%3], m[(j+2)%3]
+			r[i][j] = (a[(i+1)%3]*b[(i+2)%3] - a[(i+2)%3]*b[(i+1)%3]) / det
+		}
+	}
+	return r
+}
+
+// ChromaticityDiagram is a Projection plotting colors on the CIE 1931 xy chromaticity diagram where
+// higher luminance (Y) wins. It draws the spectral locus as its background and the triangles of the
+// reference gamuts over the colors, showing at a glance whether the colors of an image use a wide gamut.
+// Pixels are taken to be encoded in the source gamut, so they never leave its triangle.
+//
+// The canvas covers x from 0 to 0.8 and y from 0 to 0.9 (stretched on canvases that aren't square),
+// with y pointing up.
+type ChromaticityDiagram struct {
+	source     Gamut
+	toXYZ      [3][3]float64
+	references []Gamut
+}
+
+// NewChromaticityDiagram returns the diagram of colors encoded in source with the triangles of references drawn over
+func NewChromaticityDiagram(source Gamut, references ...Gamut) *ChromaticityDiagram {
+	return &ChromaticityDiagram{source: source, toXYZ: source.toXYZ(), references: references}
+}
+
+var (
+	// CIExy is the chromaticity diagram of sRGB colors with the sRGB, Display P3 and Rec.2020 triangles
+	CIExy Projection = NewChromaticityDiagram(SRGB, SRGB, DisplayP3, Rec2020)
+	// CIExyP3 is CIExy for colors of images encoded in Display P3
+	CIExyP3 Projection = NewChromaticityDiagram(DisplayP3, SRGB, DisplayP3, Rec2020)
+	// CIExyRec2020 is CIExy for colors of images encoded in Rec.2020
+	CIExyRec2020 Projection = NewChromaticityDiagram(Rec2020, SRGB, DisplayP3, Rec2020)
+)
+
+// Center and half of the side of the square of the diagram covered by the canvas
+const (
+	diagramX, diagramY = 0.4, 0.45
+	diagramHalf        = 0.45
+)
+
+// Project implements Projection
+func (d *ChromaticityDiagram) Project(r, g, b uint32) (x, y, key float64) {
+	linear := [3]float64{
+		d.source.Linearize(float64(r) / float64(0xFFFF)),
+		d.source.Linearize(float64(g) / float64(0xFFFF)),
+		d.source.Linearize(float64(b) / float64(0xFFFF)),
+	}
+	xyz := multiply(d.toXYZ, linear)
+	sum := xyz[0] + xyz[1] + xyz[2]
+	if sum <= 0 {
+		// Black has no chromaticity
+		u, v := d.point(d.source.White)
+		return u, v, 0
+	}
+	u, v := d.point(XY{xyz[0] / sum, xyz[1] / sum})
+	return u, v, xyz[1]
+}
+
+// point returns where a chromaticity lands in the square from -1 to 1
+func (d *ChromaticityDiagram) point(c XY) (u, v float64) {
+	return (c.X - diagramX) / diagramHalf, (diagramY - c.Y) / diagramHalf
+}
+
+// DrawBackground implements Background, filling the spectral locus with black
+func (d *ChromaticityDiagram) DrawBackground(context *gg.Context, opts Options) {
+	d.tracePath(context, opts, spectralLocus)
+	context.ClosePath()
+	context.SetRGB(0, 0, 0)
+	context.Fill()
+}
+
+// DrawOverlay implements Overlay, outlining the spectral locus and the triangles of the reference gamuts
+func (d *ChromaticityDiagram) DrawOverlay(context *gg.Context, opts Options) {
+	context.SetLineWidth(1)
+	d.tracePath(context, opts, spectralLocus)
+	context.ClosePath()
+	context.SetRGB(0.5, 0.5, 0.5)
+	context.Stroke()
+
+	for i, gamut := range d.references {
+		d.tracePath(context, opts, []XY{gamut.Red, gamut.Green, gamut.Blue})
+		context.ClosePath()
+		c := referenceColors[i%len(referenceColors)]
+		context.SetRGB(c[0], c[1], c[2])
+		context.Stroke()
+	}
+	if len(d.references) > 0 {
+		x, y := opts.CanvasPoint(d.point(d.references[0].White))
+		context.DrawCircle(x, y, 1.5)
+		context.SetRGB(1, 1, 1)
+		context.Fill()
+	}
+}
+
+// referenceColors are the colors of the triangles of the reference gamuts, in order
+var referenceColors = [][3]float64{{1, 1, 1}, {1, 0.6, 0.2}, {0.3, 0.7, 1}}
+
+func (d *ChromaticityDiagram) tracePath(context *gg.Context, opts Options, points []XY) {
+	context.NewSubPath()
+	for _, p := range points {
+		context.LineTo(opts.CanvasPoint(d.point(p)))
+	}
+}
+
+// spectralLocus is the chromaticity of monochromatic light from 380 to 700nm by 5nm
+// for the CIE 1931 2° standard observer
+var spectralLocus = []XY{
+	{0.1741, 0.0050}, {0.1740, 0.0050}, {0.1738, 0.0049}, {0.1736, 0.0049}, // 380nm
+	{0.1733, 0.0048}, {0.1730, 0.0048}, {0.1726, 0.0048}, {0.1721, 0.0048}, // 400nm
+	{0.1714, 0.0051}, {0.1703, 0.0058}, {0.1689, 0.0069}, {0.1669, 0.0086}, // 420nm
+	{0.1644, 0.0109}, {0.1611, 0.0138}, {0.1566, 0.0177}, {0.1510, 0.0227}, // 440nm
+	{0.1440, 0.0297}, {0.1355, 0.0399}, {0.1241, 0.0578}, {0.1096, 0.0868}, // 460nm
+	{0.0913, 0.1327}, {0.0687, 0.2007}, {0.0454, 0.2950}, {0.0235, 0.4127}, // 480nm
+	{0.0082, 0.5384}, {0.0039, 0.6548}, {0.0139, 0.7502}, {0.0389, 0.8120}, // 500nm
+	{0.0743, 0.8338}, {0.1142, 0.8262}, {0.1547, 0.8059}, {0.1929, 0.7816}, // 520nm
+	{0.2296, 0.7543}, {0.2658, 0.7243}, {0.3016, 0.6923}, {0.3373, 0.6589}, // 540nm
+	{0.3731, 0.6245}, {0.4087, 0.5896}, {0.4441, 0.5547}, {0.4788, 0.5202}, // 560nm
+	{0.5125, 0.4866}, {0.5448, 0.4544}, {0.5752, 0.4242}, {0.6029, 0.3965}, // 580nm
+	{0.6270, 0.3725}, {0.6482, 0.3514}, {0.6658, 0.3340}, {0.6801, 0.3197}, // 600nm
+	{0.6915, 0.3083}, {0.7006, 0.2993}, {0.7079, 0.2920}, {0.7140, 0.2859}, // 620nm
+	{0.7190, 0.2809}, {0.7230, 0.2770}, {0.7260, 0.2740}, {0.7283, 0.2717}, // 640nm
+	{0.7300, 0.2700}, {0.7311, 0.2689}, {0.7320, 0.2680}, {0.7327, 0.2673}, // 660nm
+	{0.7334, 0.2666}, {0.7340, 0.2660}, {0.7344, 0.2656}, {0.7346, 0.2654}, // 680nm
+	{0.7347, 0.2653}, // 700nm
+}
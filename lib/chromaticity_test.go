@@ -0,0 +1,95 @@
+package lib_test
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/zzwx/gamutmask/lib"
+	"github.com/zzwx/gamutmask/lib/gamuttest"
+)
+
+// chromaticity returns the xy chromaticity of the point projection places r, g, b at
+func chromaticity(projection lib.Projection, r, g, b uint32) (lib.XY, float64) {
+	u, v, key := projection.Project(r, g, b)
+	return lib.XY{X: 0.4 + u*0.45, Y: 0.45 - v*0.45}, key
+}
+
+func TestCIExy(t *testing.T) {
+	for _, gamut := range []lib.Gamut{lib.SRGB, lib.DisplayP3, lib.Rec2020} {
+		diagram := lib.NewChromaticityDiagram(gamut)
+		for _, test := range []struct {
+			r, g, b uint32
+			want    lib.XY
+		}{
+			{0xFFFF, 0, 0, gamut.Red},
+			{0, 0xFFFF, 0, gamut.Green},
+			{0, 0, 0xFFFF, gamut.Blue},
+			{0xFFFF, 0xFFFF, 0xFFFF, gamut.White},
+			{0x8000, 0x8000, 0x8000, gamut.White},
+			{0, 0, 0, gamut.White}, // Black has no chromaticity of its own
+		} {
+			got, _ := chromaticity(diagram, test.r, test.g, test.b)
+			if math.Abs(got.X-test.want.X) > 1e-9 || math.Abs(got.Y-test.want.Y) > 1e-9 {
+				t.Errorf("%s: %04x %04x %04x is at %v, want %v", gamut.Name, test.r, test.g, test.b, got, test.want)
+			}
+		}
+		// Luminance decides which color wins, white having Y = 1
+		if _, key := chromaticity(diagram, 0xFFFF, 0xFFFF, 0xFFFF); math.Abs(key-1) > 1e-9 {
+			t.Errorf("%s: white has a luminance of %v, want 1", gamut.Name, key)
+		}
+	}
+
+	opts := lib.DefaultOptions
+	opts.Projection = lib.CIExy
+	gamuttest.ExpectLandsAt(t, opts, color.RGBA{255, 0, 0, 255}, image.Pt(190, 157), 1) // x 0.64, y 0.33
+	gamuttest.ExpectLandsAt(t, opts, color.RGBA{0, 0, 255, 255}, image.Pt(56, 231), 1)  // x 0.15, y 0.06
+
+	// The spectral locus is filled, the rest of the canvas left transparent
+	wheel, err := lib.GenerateGamutMaskWithOptions(gamuttest.Solid(color.RGBA{255, 0, 0, 255}, 4, 4), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := wheel.RGBA64At(43, 84); c != (color.RGBA64{0, 0, 0, 0xFFFF}) { // x 0.1, y 0.6
+		t.Errorf("got %v within the spectral locus, want black", c)
+	}
+	if c := wheel.RGBA64At(245, 5); c.A != 0 { // x 0.78, y 0.88
+		t.Errorf("got %v out of the spectral locus, want transparent", c)
+	}
+}
+
+// TestGamutToXYZ checks the matrix of sRGB against the one published along with the standard
+func TestGamutToXYZ(t *testing.T) {
+	want := [3][3]float64{
+		{0.4124, 0.3576, 0.1805},
+		{0.2126, 0.7152, 0.0722},
+		{0.0193, 0.1192, 0.9505},
+	}
+	got := lib.SRGB.ToXYZ()
+	for i := range want {
+		for j := range want[i] {
+			if math.Abs(got[i][j]-want[i][j]) > 1e-3 {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		}
+	}
+
+	// Inverting it gives the matrix converting back to linear sRGB
+	inverse := lib.Invert(got)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			var sum float64
+			for k := 0; k < 3; k++ {
+				sum += inverse[i][k] * got[k][j]
+			}
+			identity := 0.0
+			if i == j {
+				identity = 1
+			}
+			if math.Abs(sum-identity) > 1e-12 {
+				t.Errorf("product of the inverse at %d, %d is %v", i, j, sum)
+			}
+		}
+	}
+}
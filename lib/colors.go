@@ -0,0 +1,44 @@
+package lib
+
+// ColorCounts counts the samples of every exact color, kept with its 16-bit components.
+// See GamutAccumulator.CountColors.
+type ColorCounts struct {
+	counts  map[uint64]uint64
+	samples uint64
+}
+
+// NewColorCounts creates empty counts
+func NewColorCounts() *ColorCounts {
+	return &ColorCounts{counts: map[uint64]uint64{}}
+}
+
+// Add counts n samples of a color with 16-bit components
+func (c *ColorCounts) Add(r, g, b uint16, n uint64) {
+	c.counts[uint64(r)<<32|uint64(g)<<16|uint64(b)] += n
+	c.samples += n
+}
+
+// Len returns the amount of different colors counted
+func (c *ColorCounts) Len() int {
+	return len(c.counts)
+}
+
+// Samples returns the amount of samples counted
+func (c *ColorCounts) Samples() uint64 {
+	return c.samples
+}
+
+// Each calls f with every color counted and its amount of samples, in no particular order
+func (c *ColorCounts) Each(f func(r, g, b uint16, n uint64)) {
+	for key, n := range c.counts {
+		f(uint16(key>>32), uint16(key>>16), uint16(key), n)
+	}
+}
+
+// Merge adds the samples counted by other
+func (c *ColorCounts) Merge(other *ColorCounts) {
+	for key, n := range other.counts {
+		c.counts[key] += n
+	}
+	c.samples += other.samples
+}
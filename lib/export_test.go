@@ -0,0 +1,15 @@
+package lib
+
+// Exposing the matrices of the chromaticity diagram to the tests of lib_test
+var Invert = invert
+
+func (g Gamut) ToXYZ() [3][3]float64 {
+	return g.toXYZ()
+}
+
+// Exposing the bundled renotation data and the conversion it is measured with to the tests of Munsell colors
+const MunsellRenotationData = munsellRenotationData
+
+func SRGBToC() [3][3]float64 {
+	return srgbToC
+}
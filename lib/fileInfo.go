@@ -1,13 +1,13 @@
 package lib
 
 import (
-	"crypto/md5"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"math"
 	"os"
+	"sort"
 	"sync"
 	"time"
 )
@@ -18,12 +18,21 @@ type FileInfoList []FileInfo
 
 // FileInfo type is to keep data about input folder files that have been processed
 type FileInfo struct {
-	InputName   string
-	OutputName  string
+	InputName  string
+	OutputName string
+	// MD5 is only read from manifests written before Hash and Hasher were introduced
 	MD5         string    `json:",omitempty"`
+	Hash        string    `json:",omitempty"` // Hash of the input file, only calculated when Size, CreatedAt or Inode change
+	Hasher      string    `json:",omitempty"` // Name of the Hasher of Hash
 	Size        int64     // Excessive data in case MD5 appears the same
 	CreatedAt   time.Time // Excessive data in case MD5 appears the same
+	Inode       uint64    `json:",omitempty"` // Inode number of the input file, where available
 	ProcessedAt time.Time
+	// Skipped is the reason the file has been skipped, so it is not processed again until it changes
+	// or ProcessOptions.SkipKey does
+	Skipped string `json:",omitempty"`
+	// SkipKey is the ProcessOptions.SkipKey the file has been skipped under
+	SkipKey string `json:",omitempty"`
 	// A hidden flag for processing removal data from JSON only
 	FileFound bool `json:"-"`
 }
@@ -33,9 +42,27 @@ func unmarshalJSON(r io.Reader) (*FileInfoList, error) {
 	if err := json.NewDecoder(r).Decode(&result); err != nil {
 		return nil, err
 	}
+	for i := range result {
+		if result[i].Hash == "" && result[i].MD5 != "" {
+			result[i].Hash, result[i].Hasher, result[i].MD5 = result[i].MD5, MD5Hasher.Name, ""
+		}
+	}
 	return &result, nil
 }
 
+// sameStat tells if the size, modification time and inode (when known) of the file are the recorded ones
+func (fileInfo *FileInfo) sameStat(f os.FileInfo) bool {
+	return fileInfo.Size == f.Size() && fileInfo.CreatedAt.Equal(f.ModTime()) &&
+		(fileInfo.Inode == 0 || fileInfo.Inode == inode(f))
+}
+
+// setStat records the size, modification time and inode of the file
+func (fileInfo *FileInfo) setStat(f os.FileInfo) {
+	fileInfo.Size = f.Size()
+	fileInfo.CreatedAt = f.ModTime()
+	fileInfo.Inode = inode(f)
+}
+
 func marshalJSON(w io.Writer, data *FileInfoList) error {
 	encoder := json.NewEncoder(w)
 	encoder.SetIndent("", "  ")
@@ -44,6 +71,113 @@ func marshalJSON(w io.Writer, data *FileInfoList) error {
 
 var mtx sync.Mutex
 
+// manifestCache keeps the manifests last read or written by their file names, guarded by mtx,
+// so the ones that haven't changed since are not unmarshalled on every pass
+var manifestCache = map[string]cachedManifest{}
+
+type cachedManifest struct {
+	modTime time.Time
+	size    int64
+	list    FileInfoList
+}
+
+// loadManifest reads the manifest from fileName, returning false if it doesn't exist or can't be unmarshalled
+func loadManifest(fileName string) (FileInfoList, bool) {
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		return nil, false
+	}
+	if cached, ok := manifestCache[fileName]; ok && cached.size == stat.Size() && cached.modTime.Equal(stat.ModTime()) {
+		return append(FileInfoList(nil), cached.list...), true
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+	result, err := unmarshalJSON(file)
+	if err != nil {
+		return nil, false
+	}
+	manifestCache[fileName] = cachedManifest{
+		modTime: stat.ModTime(),
+		size:    stat.Size(),
+		list:    append(FileInfoList(nil), *result...),
+	}
+	return *result, true
+}
+
+// saveManifest writes the manifest into fileName
+func saveManifest(fileName string, list FileInfoList) error {
+	delete(manifestCache, fileName)
+	out, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("can't create json file info file: %w", err)
+	}
+	if err := marshalJSON(out, &list); err != nil {
+		out.Close()
+		return fmt.Errorf("error marshalling json: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("can't write json file info file: %w", err)
+	}
+	if stat, err := os.Stat(fileName); err == nil {
+		cached := cachedManifest{modTime: stat.ModTime(), size: stat.Size(), list: append(FileInfoList(nil), list...)}
+		for i := range cached.list {
+			cached.list[i].FileFound = false
+		}
+		manifestCache[fileName] = cached
+	}
+	return nil
+}
+
+// SkipError is returned by processFileFunc of ProcessChangedFilesOnly for files not to be processed again
+// until they change, even though they have no output file. The reason is recorded in FileInfo.Skipped.
+type SkipError struct {
+	Err error
+}
+
+func (e *SkipError) Error() string {
+	return "skipped: " + e.Err.Error()
+}
+
+func (e *SkipError) Unwrap() error {
+	return e.Err
+}
+
+// pendingFile is a file to process with the index of its record
+// and what is recorded about it once it has been processed
+type pendingFile struct {
+	index int
+	stat  os.FileInfo
+	hash  string // Calculated after processing when empty
+}
+
+// ProcessOptions tune how ProcessChangedFilesOnlyWithOptions processes files
+type ProcessOptions struct {
+	// Jobs is the amount of files processed concurrently, one at a time when zero.
+	// processFileFunc has to be safe for concurrent use when it is more than one.
+	Jobs int
+
+	// Hasher hashes the files which size, modification time or inode have changed, MD5Hasher when zero.
+	// Files recorded with another hasher are processed again once they change.
+	Hasher Hasher
+	// VerifyHashes hashes every file on every pass instead of trusting unchanged size, modification time and inode
+	VerifyHashes bool
+
+	// SkipKey describes what files are skipped against, like the limits (see Limits.String).
+	// Skipped files are processed again once it changes.
+	SkipKey string
+}
+
+// hasher returns the Hasher to use, MD5Hasher by default
+func (opts ProcessOptions) hasher() Hasher {
+	if opts.Hasher.New == nil {
+		return MD5Hasher
+	}
+	return opts.Hasher
+}
+
 // ProcessChangedFilesOnly will check if the input folder has any changes by comparing files against the
 // info saved in fileInfoListJSONFullFileName file, which will be generated and saved if does not exist.
 //
@@ -67,6 +201,28 @@ func ProcessChangedFilesOnly(
 	processFileFunc func(fullInputFileName string, fullOutputFileName string) (int, error),
 	beforeDeleteCallback func(folderName, fileName string) bool) error {
 
+	return ProcessChangedFilesOnlyWithOptions(inputFolderName,
+		outputFolderName,
+		outputFileName,
+		isInputFileForProcessing,
+		fileInfoListJSONFullFileName,
+		processFileFunc,
+		beforeDeleteCallback,
+		ProcessOptions{})
+}
+
+// ProcessChangedFilesOnlyWithOptions is ProcessChangedFilesOnly processing up to opts.Jobs files concurrently.
+// The manifest is sorted by InputName, so it doesn't depend on the order the files are done in.
+func ProcessChangedFilesOnlyWithOptions(
+	inputFolderName string,
+	outputFolderName string,
+	outputFileName func(inputFileName string) string,
+	isInputFileForProcessing func(inputFolderName, inputFileName string) bool,
+	fileInfoListJSONFullFileName string,
+	processFileFunc func(fullInputFileName string, fullOutputFileName string) (int, error),
+	beforeDeleteCallback func(folderName, fileName string) bool,
+	opts ProcessOptions) error {
+
 	// We don't want this function to be called simultaneously
 	mtx.Lock()
 	defer mtx.Unlock()
@@ -76,97 +232,146 @@ func ProcessChangedFilesOnly(
 		return fmt.Errorf("input folder read dir error: %w", err)
 	}
 
-	var fileInfoList FileInfoList
+	// Restore data from the file, if exists. If it doesn't or can't be unmarshalled,
+	// we simply assume that it could be safely replaced.
+	fileInfoList, restored := loadManifest(fileInfoListJSONFullFileName)
+	changed := !restored // The manifest is only written when it changes
 
-	// Restore data from the file, if exists
-	file, err := os.Open(fileInfoListJSONFullFileName)
-	if err != nil {
-		// If the file doesn't exist, we'll generate it
-	} else {
-		defer file.Close()
-		result, err := unmarshalJSON(file)
-		if err != nil {
-			// We simply assume that if we can't unmarshal the file, then it could be safely replaced.
-		} else {
-			fileInfoList = append(fileInfoList, *result...)
-		}
+	hasher := opts.hasher()
+	byInputName := fileInfoList.byInputName()
+	outputFolderFiles, outputFolderErr := readFolderNames(outputFolderName)
+	outputNames := make(map[string]bool, len(outputFolderFiles))
+	for _, name := range outputFolderFiles {
+		outputNames[outputFolderName+"/"+name] = true
 	}
 
-	// Now we'll read the folder and see if data has existed in JSON
+	// Now we'll read the folder and see if data has existed in JSON.
+	// Files to process are only collected here, each one with the index of its record in fileInfoList.
+	var toProcess []pendingFile
 	for _, f := range osInputFolderFiles {
 		inputFileName := f.Name()
 		if !f.IsDir() && isInputFileForProcessing(inputFolderName, inputFileName) {
 			outputFileName := outputFileName(inputFileName)
 
 			foundIndex := -1
-			processedMD5 := "" // To avoid calculating it twice
 			processIt := false
-			for index, fileInfo := range fileInfoList {
-				if fileInfo.InputName == inputFolderName+"/"+inputFileName {
-					foundIndex = index
-					newMD5 := GetFileMD5(inputFolderName + "/" + inputFileName)
-					// The file has changed?
-					if fileInfo.Size != f.Size() || fileInfo.CreatedAt != f.ModTime() || fileInfo.MD5 != newMD5 {
-						processIt = true
-						processedMD5 = newMD5
-					}
-					// The output file doesn't exist?
-					if _, err := os.Stat(outputFolderName + "/" + outputFileName); os.IsNotExist(err) {
+			hash := "" // To be calculated after processing
+			if index, ok := byInputName[inputFolderName+"/"+inputFileName]; ok {
+				foundIndex = index
+				fileInfo := &fileInfoList[index]
+				// The file has changed? Only hashing it when its size is the same but the rest has changed
+				if !fileInfo.sameStat(f) || fileInfo.Hash == "" || opts.VerifyHashes {
+					if fileInfo.Size != f.Size() {
 						processIt = true
-						processedMD5 = newMD5
+					} else {
+						newHash, err := HashFile(inputFolderName+"/"+inputFileName, hasher)
+						if err != nil || fileInfo.Hasher != hasher.Name || fileInfo.Hash != newHash {
+							processIt = true
+							hash = newHash // To avoid calculating it twice
+						} else if !fileInfo.sameStat(f) {
+							fileInfo.setStat(f) // Only touched
+							changed = true
+						}
 					}
-					fileInfoList[index].FileFound = true // Mark it as found so it won't be removed (Can't do fileInfo.FileFound, since it's a copy)
-					break
 				}
+				// The output file doesn't exist (and the file hasn't been skipped for good reason)?
+				if !outputNames[outputFolderName+"/"+outputFileName] && fileInfo.Skipped == "" {
+					processIt = true
+				}
+				// The file has been skipped under other limits?
+				if fileInfo.Skipped != "" && fileInfo.SkipKey != opts.SkipKey {
+					processIt = true
+				}
+				fileInfo.FileFound = true // Mark it as found so it won't be removed
+			}
+
+			if foundIndex < 0 {
+				foundIndex = len(fileInfoList)
+				fileInfoList = append(fileInfoList, FileInfo{
+					InputName:  inputFolderName + "/" + inputFileName,
+					OutputName: outputFolderName + "/" + outputFileName,
+					FileFound:  true, // Mark it as found so it won't be removed
+				})
+				processIt = true
+			}
+			if processIt {
+				toProcess = append(toProcess, pendingFile{index: foundIndex, stat: f, hash: hash})
 			}
+		}
+	}
 
-			if foundIndex < 0 || processIt {
-				processFileFunc(inputFolderName+"/"+inputFileName, outputFolderName+"/"+outputFileName)
-				if processedMD5 == "" {
-					processedMD5 = GetFileMD5(inputFolderName + "/" + inputFileName)
+	// Every job only updates the record of its own file. The file is only recorded as up to date once it has been
+	// processed or skipped, so files failing (or stopped by cancellation) are processed again on the next pass.
+	jobs := opts.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	pending := make(chan pendingFile)
+	var wg sync.WaitGroup
+	for job := 0; job < jobs; job++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range pending {
+				fileInfo := &fileInfoList[p.index]
+				_, err := processFileFunc(fileInfo.InputName, fileInfo.OutputName)
+				var skip *SkipError
+				if err != nil && !errors.As(err, &skip) {
+					continue
+				}
+				fileInfo.Skipped, fileInfo.SkipKey = "", ""
+				if skip != nil {
+					fileInfo.Skipped, fileInfo.SkipKey = skip.Err.Error(), opts.SkipKey
 				}
-				if foundIndex < 0 {
-					fileInfoList = append(fileInfoList, FileInfo{
-						InputName:   inputFolderName + "/" + inputFileName,
-						OutputName:  outputFolderName + "/" + outputFileName,
-						MD5:         processedMD5,
-						Size:        f.Size(),
-						CreatedAt:   f.ModTime(),
-						ProcessedAt: time.Now(),
-						FileFound:   true, // Mark it as found so it won't be removed
-					})
-				} else {
-					fileInfoList[foundIndex].MD5 = processedMD5
-					fileInfoList[foundIndex].Size = f.Size()
-					fileInfoList[foundIndex].CreatedAt = f.ModTime()
-					fileInfoList[foundIndex].ProcessedAt = time.Now()
-					fileInfoList[foundIndex].FileFound = true // Mark it as found so it won't be removed
+				fileInfo.setStat(p.stat)
+				fileInfo.Hash, fileInfo.Hasher = p.hash, hasher.Name
+				if fileInfo.Hash == "" {
+					fileInfo.Hash, _ = HashFile(fileInfo.InputName, hasher) // Left empty for the file to be hashed again
 				}
+				fileInfo.ProcessedAt = time.Now()
 			}
-		}
+		}()
+	}
+	for _, p := range toProcess {
+		pending <- p
 	}
+	close(pending)
+	wg.Wait()
+
 	// Get rid of the ones were in JSON that were not found as files (due to "fileFound" flag)
-	for index := 0; index < len(fileInfoList); index++ {
-		if !fileInfoList[index].FileFound {
-			fileInfoList = append(fileInfoList[:index], fileInfoList[index+1:]...)
-			index-- // To make it re-run the index that will be index++
+	found := fileInfoList[:0]
+	for _, fileInfo := range fileInfoList {
+		if fileInfo.FileFound {
+			found = append(found, fileInfo)
 		}
 	}
-
-	out, err := os.Create(fileInfoListJSONFullFileName)
-	if err != nil {
-		return fmt.Errorf("can't create json file info file: %w", err)
+	if len(found) != len(fileInfoList) || len(toProcess) > 0 {
+		changed = true
+	}
+	fileInfoList = found
+	byName := func(i, j int) bool {
+		return fileInfoList[i].InputName < fileInfoList[j].InputName
+	}
+	if !sort.SliceIsSorted(fileInfoList, byName) {
+		sort.Slice(fileInfoList, byName)
+		changed = true
 	}
-	defer out.Close()
 
-	err = marshalJSON(out, &fileInfoList)
-	if err != nil {
-		return fmt.Errorf("error marshalling json: %w", err)
+	if changed {
+		if err := saveManifest(fileInfoListJSONFullFileName, fileInfoList); err != nil {
+			return err
+		}
 	}
 
 	// And now we'll remove the unnecessary files from output folder that don't exist in JSON
 	// Remove unnecessary files from output folder
-	return SanitizeOutputFolder(outputFolderName, beforeDeleteCallback, &fileInfoList)
+	if beforeDeleteCallback == nil {
+		return nil
+	}
+	if outputFolderErr != nil {
+		return fmt.Errorf("output folder scan error: %w", outputFolderErr)
+	}
+	return sanitizeOutputFolder(outputFolderName, outputFolderFiles, beforeDeleteCallback, &fileInfoList)
 }
 
 // ProcessChangedFilesOnlyRecursively walks through inputFolderName and all subfolders of it recursively
@@ -183,31 +388,55 @@ func ProcessChangedFilesOnlyRecursively(
 	processFileFunc func(fullInputFileName string, fullOutputFileName string) (int, error),
 	beforeDeleteCallback func(folderName, fileName string) bool) error {
 
+	return ProcessChangedFilesOnlyRecursivelyWithOptions(inputFolderName,
+		outputFolderName,
+		outputFileName,
+		isInputFileForProcessing,
+		fileInfoListJSONFullFileName,
+		processFileFunc,
+		beforeDeleteCallback,
+		ProcessOptions{})
+}
+
+// ProcessChangedFilesOnlyRecursivelyWithOptions is ProcessChangedFilesOnlyRecursively
+// processing up to opts.Jobs files of every folder concurrently
+func ProcessChangedFilesOnlyRecursivelyWithOptions(
+	inputFolderName string,
+	outputFolderName string,
+	outputFileName func(inputFileName string) string,
+	isInputFileForProcessing func(inputFolderName, inputFileName string) bool,
+	fileInfoListJSONFullFileName func(inputFolderName string) string,
+	processFileFunc func(fullInputFileName string, fullOutputFileName string) (int, error),
+	beforeDeleteCallback func(folderName, fileName string) bool,
+	opts ProcessOptions) error {
+
 	osInputFolderFiles, err := ioutil.ReadDir(inputFolderName)
 	if err != nil {
 		return fmt.Errorf("input folder read dir error: %w", err)
 	}
 
-	err = ProcessChangedFilesOnly(inputFolderName,
+	err = ProcessChangedFilesOnlyWithOptions(inputFolderName,
 		outputFolderName,
 		outputFileName,
 		isInputFileForProcessing,
 		fileInfoListJSONFullFileName(inputFolderName),
 		processFileFunc,
-		beforeDeleteCallback)
+		beforeDeleteCallback,
+		opts)
 	if err != nil {
 		return err
 	}
 
 	for _, f := range osInputFolderFiles {
 		if f.IsDir() {
-			err := ProcessChangedFilesOnlyRecursively(inputFolderName + "/" +f.Name(),
-				outputFolderName + "/" + f.Name(),
+			err := ProcessChangedFilesOnlyRecursivelyWithOptions(inputFolderName+"/"+f.Name(),
+				outputFolderName+"/"+f.Name(),
 				outputFileName,
 				isInputFileForProcessing,
 				fileInfoListJSONFullFileName,
 				processFileFunc,
-				beforeDeleteCallback)
+				beforeDeleteCallback,
+				opts)
 			if err != nil {
 				return err
 			}
@@ -234,57 +463,73 @@ func SanitizeOutputFolder(
 		return nil
 	}
 
-	outputFolderFiles, err := ioutil.ReadDir(outputFolderName)
+	outputFolderFiles, err := readFolderNames(outputFolderName)
 	if err != nil {
 		return fmt.Errorf("output folder scan error: %w", err)
 	}
-	for _, f := range outputFolderFiles {
-		if !f.IsDir() && !isInFileInfoListAsOutputName(fileInfoList, outputFolderName, f.Name()) {
-			if beforeDeleteCallback(outputFolderName, f.Name()) {
-				if err := os.Remove(outputFolderName + "/" + f.Name()); err != nil {
-					return fmt.Errorf("remove file error: %w", err)
-				}
+	return sanitizeOutputFolder(outputFolderName, outputFolderFiles, beforeDeleteCallback, fileInfoList)
+}
+
+// sanitizeOutputFolder is SanitizeOutputFolder with the names of the files of the output folder already read
+func sanitizeOutputFolder(
+	outputFolderName string,
+	outputFolderFiles []string,
+	beforeDeleteCallback func(folderName, fileName string) bool,
+	fileInfoList *FileInfoList) error {
+
+	outputNames := fileInfoList.outputNames()
+	for _, name := range outputFolderFiles {
+		if outputNames[outputFolderName+"/"+name] {
+			continue // Only the files not in the list are looked at closer
+		}
+		if f, err := os.Lstat(outputFolderName + "/" + name); err != nil || f.IsDir() {
+			continue
+		}
+		if beforeDeleteCallback(outputFolderName, name) {
+			if err := os.Remove(outputFolderName + "/" + name); err != nil {
+				return fmt.Errorf("remove file error: %w", err)
 			}
 		}
 	}
 	return nil
 }
 
-func isInFileInfoListAsInputName(list *FileInfoList, inputFolderName, item string) bool {
-	for _, l := range *list {
-		if l.InputName == (inputFolderName + "/" + item) {
-			return true
+// byInputName returns the indices of the records by InputName
+func (list FileInfoList) byInputName() map[string]int {
+	indices := make(map[string]int, len(list))
+	for index, fileInfo := range list {
+		if _, ok := indices[fileInfo.InputName]; !ok {
+			indices[fileInfo.InputName] = index
 		}
 	}
-	return false
+	return indices
 }
 
-func isInFileInfoListAsOutputName(list *FileInfoList, outputFolderName, item string) bool {
-	for _, l := range *list {
-		if l.OutputName == (outputFolderName + "/" + item) {
-			return true
-		}
+// outputNames returns the set of OutputNames of the records
+func (list FileInfoList) outputNames() map[string]bool {
+	names := make(map[string]bool, len(list))
+	for _, fileInfo := range list {
+		names[fileInfo.OutputName] = true
 	}
-	return false
+	return names
 }
 
-// GetFileMD5 will open the file, calculate and return its MD5 as a sequence of Hex symbols
-func GetFileMD5(fileName string) string {
-	const fileChunk = 8192 // we settle for 8KB
-	file, err := os.Open(fileName)
+// readFolderNames returns the sorted names of the files and folders of folderName without reading the info of every file
+func readFolderNames(folderName string) ([]string, error) {
+	folder, err := os.Open(folderName)
 	if err != nil {
-		return "" // simply skip the file that has been removed
+		return nil, err
 	}
-	defer file.Close()
-	info, _ := file.Stat()
-	fileSize := info.Size()
-	blocks := uint64(math.Ceil(float64(fileSize) / float64(fileChunk)))
-	hash := md5.New()
-	for i := uint64(0); i < blocks; i++ {
-		blockSize := int(math.Min(fileChunk, float64(fileSize-int64(i*fileChunk))))
-		buf := make([]byte, blockSize)
-		file.Read(buf)
-		io.WriteString(hash, string(buf)) // append into the hash
-	}
-	return fmt.Sprintf("%x", hash.Sum(nil))
+	defer folder.Close()
+	names, err := folder.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetFileMD5 will open the file, calculate and return its MD5 as a sequence of Hex symbols
+func GetFileMD5(fileName string) (string, error) {
+	return HashFile(fileName, MD5Hasher)
 }
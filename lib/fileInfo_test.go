@@ -0,0 +1,402 @@
+package lib_test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zzwx/gamutmask/lib"
+)
+
+// processing runs ProcessChangedFilesOnlyWithOptions over the files of a temporary input folder
+// with a processFileFunc writing the output files and counting the calls
+type processing struct {
+	t          testing.TB
+	in, out    string
+	opts       lib.ProcessOptions
+	errs       map[string]error // Returned for the input files of the names instead of writing the output
+	mtx        sync.Mutex
+	calls      map[string]int
+	running    int
+	maxRunning int
+}
+
+func newProcessing(t testing.TB, dir string) *processing {
+	p := &processing{t: t, in: filepath.Join(dir, "in"), out: filepath.Join(dir, "out"), errs: map[string]error{}}
+	for _, folder := range []string{p.in, p.out} {
+		if err := os.Mkdir(folder, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p
+}
+
+// write writes content into the input file called name, modified at modTime
+func (p *processing) write(name, content string, modTime time.Time) {
+	p.t.Helper()
+	fileName := filepath.Join(p.in, name)
+	if err := ioutil.WriteFile(fileName, []byte(content), 0600); err != nil {
+		p.t.Fatal(err)
+	}
+	if err := os.Chtimes(fileName, modTime, modTime); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+// run processes the folder once and returns the names of the files processed, sorted
+func (p *processing) run() []string {
+	p.t.Helper()
+	p.calls = map[string]int{}
+	err := lib.ProcessChangedFilesOnlyWithOptions(p.in, p.out,
+		func(inputFileName string) string {
+			return inputFileName + ".png"
+		},
+		func(inputFolderName, inputFileName string) bool {
+			return filepath.Ext(inputFileName) == ".jpg"
+		},
+		filepath.Join(p.in, "_list.json"),
+		p.process,
+		func(folderName, fileName string) bool {
+			return true
+		},
+		p.opts)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	var names []string
+	for name, calls := range p.calls {
+		if calls != 1 {
+			p.t.Errorf("%s processed %d times", name, calls)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *processing) process(inputFileName, outputFileName string) (int, error) {
+	name := filepath.Base(inputFileName)
+	p.mtx.Lock()
+	p.calls[name]++
+	p.running++
+	if p.running > p.maxRunning {
+		p.maxRunning = p.running
+	}
+	err := p.errs[name]
+	p.mtx.Unlock()
+	defer func() {
+		p.mtx.Lock()
+		p.running--
+		p.mtx.Unlock()
+	}()
+
+	if p.opts.Jobs > 1 {
+		time.Sleep(5 * time.Millisecond) // Giving the other jobs a chance to start
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 0, ioutil.WriteFile(outputFileName, nil, 0600)
+}
+
+// manifest reads the records of the input folder
+func (p *processing) manifest() lib.FileInfoList {
+	p.t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join(p.in, "_list.json"))
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	var list lib.FileInfoList
+	if err := json.Unmarshal(data, &list); err != nil {
+		p.t.Fatal(err)
+	}
+	return list
+}
+
+// record returns the record of the input file called name
+func (p *processing) record(name string) lib.FileInfo {
+	p.t.Helper()
+	for _, fileInfo := range p.manifest() {
+		if filepath.Base(fileInfo.InputName) == name {
+			return fileInfo
+		}
+	}
+	p.t.Fatalf("%s not recorded", name)
+	return lib.FileInfo{}
+}
+
+func expectProcessed(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("processed %v, want %v", got, want)
+	}
+}
+
+func TestProcessChangedFilesOnly(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	p := newProcessing(t, dir)
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	p.write("a.jpg", "aaaa", start)
+	p.write("b.jpg", "bbbb", start)
+	p.write("c.jpg", "cccc", start)
+	p.write("notes.txt", "not an image", start)
+
+	expectProcessed(t, p.run(), "a.jpg", "b.jpg", "c.jpg")
+	expectProcessed(t, p.run())
+
+	// Touched files are not processed again, only their modification time is recorded
+	p.write("a.jpg", "aaaa", start.Add(time.Minute))
+	expectProcessed(t, p.run())
+	if got := p.record("a.jpg").CreatedAt; !got.Equal(start.Add(time.Minute)) {
+		t.Errorf("recorded modification time %v, want %v", got, start.Add(time.Minute))
+	}
+
+	// Changes of the same size are found by the hash
+	p.write("b.jpg", "BBBB", start.Add(time.Minute))
+	expectProcessed(t, p.run(), "b.jpg")
+
+	// Missing output files are generated again
+	if err := os.Remove(filepath.Join(p.out, "c.jpg.png")); err != nil {
+		t.Fatal(err)
+	}
+	expectProcessed(t, p.run(), "c.jpg")
+
+	// Deleted files are forgotten along with their outputs
+	if err := os.Remove(filepath.Join(p.in, "a.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	expectProcessed(t, p.run())
+	if len(p.manifest()) != 2 {
+		t.Errorf("got %d records, want 2", len(p.manifest()))
+	}
+	if _, err := os.Stat(filepath.Join(p.out, "a.jpg.png")); !os.IsNotExist(err) {
+		t.Errorf("output of a deleted file exists: %v", err)
+	}
+}
+
+func TestProcessChangedFilesOnlyVerifyHashes(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	p := newProcessing(t, dir)
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	p.write("a.jpg", "aaaa", start)
+	p.opts.Hasher = lib.XXHasher
+	expectProcessed(t, p.run(), "a.jpg")
+	if got := p.record("a.jpg"); got.Hasher != lib.XXHasher.Name || got.Hash == "" {
+		t.Errorf("got hash %q of %q, want one of %q", got.Hash, got.Hasher, lib.XXHasher.Name)
+	}
+
+	// Changed without any change of size or modification time, only found when verifying hashes
+	p.write("a.jpg", "AAAA", start)
+	expectProcessed(t, p.run())
+	p.opts.VerifyHashes = true
+	expectProcessed(t, p.run(), "a.jpg")
+	expectProcessed(t, p.run())
+}
+
+// TestProcessChangedFilesOnlyMD5 checks the records of manifests written before hashers were introduced
+// are kept as MD5 hashes, so the files are not processed again
+func TestProcessChangedFilesOnlyMD5(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	p := newProcessing(t, dir)
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	p.write("a.jpg", "aaaa", start)
+	p.write("b.jpg", "bbbb", start)
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		if err := ioutil.WriteFile(filepath.Join(p.out, name+".png"), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sum := md5.Sum([]byte("aaaa"))
+	old := []map[string]interface{}{
+		{"InputName": filepath.Join(p.in, "a.jpg"), "OutputName": filepath.Join(p.out, "a.jpg.png"),
+			"MD5": hex.EncodeToString(sum[:]), "Size": 4, "CreatedAt": start, "ProcessedAt": start},
+		{"InputName": filepath.Join(p.in, "b.jpg"), "OutputName": filepath.Join(p.out, "b.jpg.png"),
+			"MD5": hex.EncodeToString(sum[:]), "Size": 4, "CreatedAt": start.Add(-time.Minute), "ProcessedAt": start},
+	}
+	data, err := json.Marshal(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(p.in, "_list.json"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// b.jpg has been modified since and is found different by its hash
+	expectProcessed(t, p.run(), "b.jpg")
+	if got := p.record("a.jpg"); got.Hasher != lib.MD5Hasher.Name || got.Hash != hex.EncodeToString(sum[:]) || got.MD5 != "" {
+		t.Errorf("got %+v, want the MD5 kept as the hash", got)
+	}
+	expectProcessed(t, p.run())
+}
+
+func TestHashFile(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	fileName := filepath.Join(dir, "abc")
+	if err := ioutil.WriteFile(fileName, []byte("abc"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		hasher lib.Hasher
+		want   string
+	}{
+		{lib.MD5Hasher, "900150983cd24fb0d6963f7d28e17f72"},
+		{lib.SHA256Hasher, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{lib.XXHasher, "44bc2cf5ad770999"},
+	} {
+		if got, err := lib.HashFile(fileName, test.hasher); err != nil || got != test.want {
+			t.Errorf("%s: got %q, %v, want %q", test.hasher.Name, got, err, test.want)
+		}
+	}
+	if _, err := lib.GetFileMD5(filepath.Join(dir, "missing")); err == nil {
+		t.Error("got the hash of a missing file")
+	}
+}
+
+func TestProcessChangedFilesOnlySkipped(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	p := newProcessing(t, dir)
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	p.write("huge.jpg", "huge", start)
+	p.errs["huge.jpg"] = &lib.SkipError{Err: lib.ErrTooManyPixels}
+	p.opts.SkipKey = lib.Limits{MaxPixels: 1000}.String()
+
+	expectProcessed(t, p.run(), "huge.jpg")
+	if got := p.record("huge.jpg"); got.Skipped != lib.ErrTooManyPixels.Error() || got.SkipKey != p.opts.SkipKey {
+		t.Errorf("got %+v, want skipped under %q", got, p.opts.SkipKey)
+	}
+	expectProcessed(t, p.run())
+
+	// Tried again once the limits change
+	p.opts.SkipKey = lib.Limits{MaxPixels: 2000}.String()
+	expectProcessed(t, p.run(), "huge.jpg")
+	expectProcessed(t, p.run())
+
+	// Or once the file changes
+	p.write("huge.jpg", "huger", start)
+	delete(p.errs, "huge.jpg")
+	expectProcessed(t, p.run(), "huge.jpg")
+	if got := p.record("huge.jpg"); got.Skipped != "" || got.SkipKey != "" {
+		t.Errorf("got %+v, want not skipped", got)
+	}
+}
+
+func TestProcessChangedFilesOnlyFailed(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	p := newProcessing(t, dir)
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	p.write("a.jpg", "aaaa", start)
+	p.write("b.jpg", "bbbb", start)
+	p.errs["a.jpg"] = errors.New("failed")
+
+	expectProcessed(t, p.run(), "a.jpg", "b.jpg")
+	if got := p.record("a.jpg"); !got.ProcessedAt.IsZero() || got.Hash != "" {
+		t.Errorf("got %+v, want a failed file recorded as not processed", got)
+	}
+	// Failed (or cancelled) files are processed again
+	expectProcessed(t, p.run(), "a.jpg")
+	delete(p.errs, "a.jpg")
+	expectProcessed(t, p.run(), "a.jpg")
+	expectProcessed(t, p.run())
+}
+
+func TestProcessChangedFilesOnlyLarge(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	p := newProcessing(t, dir)
+	names := p.writeMany(3000)
+
+	expectProcessed(t, p.run(), names...)
+	// Unchanged folders leave the manifest as it is
+	manifestFileName := filepath.Join(p.in, "_list.json")
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(manifestFileName, old, old); err != nil {
+		t.Fatal(err)
+	}
+	expectProcessed(t, p.run())
+	if stat, err := os.Stat(manifestFileName); err != nil || !stat.ModTime().Equal(old) {
+		t.Errorf("manifest rewritten for an unchanged folder: %v", err)
+	}
+
+	// Found by the output name as well
+	if err := os.Remove(filepath.Join(p.out, names[1234]+".png")); err != nil {
+		t.Fatal(err)
+	}
+	expectProcessed(t, p.run(), names[1234])
+	if len(p.manifest()) != len(names) {
+		t.Errorf("got %d records, want %d", len(p.manifest()), len(names))
+	}
+}
+
+// BenchmarkProcessChangedFilesOnlyUnchanged measures a pass over a large folder nothing has changed in
+func BenchmarkProcessChangedFilesOnlyUnchanged(b *testing.B) {
+	dir, remove := tempDir(b)
+	defer remove()
+	p := newProcessing(b, dir)
+	p.writeMany(20000)
+	p.run()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if names := p.run(); len(names) != 0 {
+			b.Fatalf("processed %d files of an unchanged folder", len(names))
+		}
+	}
+}
+
+// writeMany writes count input files of different sizes, returning their names sorted
+func (p *processing) writeMany(count int) []string {
+	p.t.Helper()
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	names := make([]string, count)
+	for i := range names {
+		names[i] = fmt.Sprintf("%06d.jpg", i)
+		p.write(names[i], strings.Repeat("x", i%100+1), start)
+	}
+	return names
+}
+
+func TestProcessChangedFilesOnlyJobs(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	p := newProcessing(t, dir)
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	var names []string
+	for i := 0; i < 20; i++ {
+		name := string(rune('a'+i)) + ".jpg"
+		p.write(name, strings.Repeat(name, i+1), start)
+		names = append(names, name)
+	}
+	p.opts.Jobs = 4
+
+	expectProcessed(t, p.run(), names...)
+	if p.maxRunning < 2 || p.maxRunning > 4 {
+		t.Errorf("got %d files processed at once, want 2 to 4", p.maxRunning)
+	}
+	manifest := p.manifest()
+	if len(manifest) != len(names) {
+		t.Fatalf("got %d records, want %d", len(manifest), len(names))
+	}
+	for i, fileInfo := range manifest {
+		if filepath.Base(fileInfo.InputName) != names[i] {
+			t.Errorf("record %d is %s, want %s", i, fileInfo.InputName, names[i])
+		}
+		if fileInfo.Hash == "" || fileInfo.ProcessedAt.IsZero() {
+			t.Errorf("%s recorded as not processed", fileInfo.InputName)
+		}
+	}
+}
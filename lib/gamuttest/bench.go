@@ -0,0 +1,92 @@
+package gamuttest
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"testing"
+
+	"github.com/lucasb-eyer/go-colorful"
+
+	"github.com/zzwx/gamutmask/lib"
+)
+
+// Photo returns an image of smooth gradients with some noise, decoded from JPEG
+// (as *image.YCbCr with 4:2:0 subsampling) the way typical photos are
+func Photo(width, height int) *image.YCbCr {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	random := rand.New(rand.NewSource(1))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := colorful.Hsv(360*float64(x)/float64(width), float64(y)/float64(height), 0.3+0.7*random.Float64()).Clamped()
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		panic(err) // Encoding into memory doesn't fail
+	}
+	decoded, err := jpeg.Decode(&buf)
+	if err != nil {
+		panic(err)
+	}
+	return decoded.(*image.YCbCr)
+}
+
+// Opaque hides the concrete type of img, so its pixels can only be read through At.
+// Compare benchmarks of img and Opaque(img) to measure the fast paths of lib for the concrete image types.
+func Opaque(img image.Image) image.Image {
+	return opaque{img}
+}
+
+type opaque struct {
+	image.Image
+}
+
+// BenchmarkAdd measures adding img to an accumulator created with opts
+func BenchmarkAdd(b *testing.B, img image.Image, opts lib.Options) {
+	accumulator, err := lib.NewGamutAccumulatorWithOptions(opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		accumulator.Add(img)
+	}
+}
+
+// Converted returns img converted into an image of the same bounds of the given color model:
+// one of color.RGBAModel, color.RGBA64Model, color.NRGBAModel, color.NRGBA64Model,
+// color.GrayModel or color.Gray16Model
+func Converted(img image.Image, model color.Model) image.Image {
+	bounds := img.Bounds()
+	var converted interface {
+		image.Image
+		Set(x, y int, c color.Color)
+	}
+	switch model {
+	case color.RGBAModel:
+		converted = image.NewRGBA(bounds)
+	case color.RGBA64Model:
+		converted = image.NewRGBA64(bounds)
+	case color.NRGBAModel:
+		converted = image.NewNRGBA(bounds)
+	case color.NRGBA64Model:
+		converted = image.NewNRGBA64(bounds)
+	case color.GrayModel:
+		converted = image.NewGray(bounds)
+	case color.Gray16Model:
+		converted = image.NewGray16(bounds)
+	default:
+		panic("gamuttest: unsupported color model")
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			converted.Set(x, y, img.At(x, y))
+		}
+	}
+	return converted
+}
@@ -0,0 +1,204 @@
+package gamuttest_test
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/zzwx/gamutmask/lib"
+	"github.com/zzwx/gamutmask/lib/gamuttest"
+)
+
+// recorder collects the failures of the helpers under test instead of failing the test itself
+type recorder struct {
+	testing.TB
+	mtx      sync.Mutex
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+// record runs f with a recorder, returning the failures reported
+func record(t *testing.T, f func(tb testing.TB)) []string {
+	r := &recorder{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f(r)
+	}()
+	<-done
+	return r.failures
+}
+
+func expectRGBA(t *testing.T, img image.Image, x, y int, want color.Color) {
+	t.Helper()
+	wr, wg, wb, wa := want.RGBA()
+	if r, g, b, a := img.At(x, y).RGBA(); r>>8 != wr>>8 || g>>8 != wg>>8 || b>>8 != wb>>8 || a>>8 != wa>>8 {
+		t.Errorf("got %v at %d, %d, want %v", img.At(x, y), x, y, want)
+	}
+}
+
+func TestImages(t *testing.T) {
+	solid := gamuttest.Solid(color.RGBA{10, 20, 30, 255}, 3, 2)
+	if solid.Bounds() != image.Rect(0, 0, 3, 2) {
+		t.Errorf("got %v", solid.Bounds())
+	}
+	expectRGBA(t, solid, 2, 1, color.RGBA{10, 20, 30, 255})
+
+	ramp := gamuttest.HueRamp(6, 2)
+	expectRGBA(t, ramp, 0, 1, color.RGBA{255, 0, 0, 255})   // Red at 0°
+	expectRGBA(t, ramp, 2, 0, color.RGBA{0, 255, 0, 255})   // Green at 120°
+	expectRGBA(t, ramp, 4, 1, color.RGBA{0, 0, 255, 255})   // Blue at 240°
+	expectRGBA(t, ramp, 5, 0, color.RGBA{255, 0, 255, 255}) // Magenta at 300°
+
+	gray := gamuttest.Grayscale(5, 1)
+	expectRGBA(t, gray, 0, 0, color.Gray{0})
+	expectRGBA(t, gray, 4, 0, color.Gray{255})
+
+	sweep := gamuttest.HSVSweep(6, 3, 0.5)
+	expectRGBA(t, sweep, 0, 0, color.RGBA{128, 0, 0, 255})     // Saturated at the top
+	expectRGBA(t, sweep, 0, 2, color.RGBA{128, 128, 128, 255}) // Gray at the bottom
+	expectRGBA(t, sweep, 2, 2, color.RGBA{128, 128, 128, 255})
+}
+
+func TestPhoto(t *testing.T) {
+	photo := gamuttest.Photo(30, 20)
+	if photo.Bounds() != image.Rect(0, 0, 30, 20) || photo.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+		t.Errorf("got %v of %v", photo.Bounds(), photo.SubsampleRatio)
+	}
+	// The same for every call
+	if differing, first, err := gamuttest.Diff(photo, gamuttest.Photo(30, 20), 0); err != nil || differing > 0 {
+		t.Errorf("%d pixels differ, first one at %v: %v", differing, first, err)
+	}
+
+	for _, test := range []struct {
+		model     color.Model
+		tolerance uint8 // Rounding 16-bit components to 8 bits
+	}{
+		{color.RGBAModel, 1},
+		{color.NRGBAModel, 1},
+		{color.RGBA64Model, 0},
+		{color.NRGBA64Model, 0},
+	} {
+		converted := gamuttest.Converted(photo, test.model)
+		if differing, first, err := gamuttest.Diff(photo, converted, test.tolerance); err != nil || differing > 0 {
+			t.Errorf("%T: %d pixels differ, first one at %v: %v", converted, differing, first, err)
+		}
+	}
+	if _, ok := gamuttest.Converted(photo, color.Gray16Model).(*image.Gray16); !ok {
+		t.Error("not converted into *image.Gray16")
+	}
+
+	opaque := gamuttest.Opaque(photo)
+	if _, ok := opaque.(*image.YCbCr); ok {
+		t.Error("type not hidden")
+	}
+	if differing, _, _ := gamuttest.Diff(photo, opaque, 0); differing > 0 {
+		t.Errorf("%d pixels differ", differing)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := gamuttest.Solid(color.RGBA{100, 100, 100, 255}, 4, 4)
+	b := gamuttest.Solid(color.RGBA{100, 100, 100, 255}, 4, 4)
+	b.Set(1, 2, color.RGBA{103, 100, 100, 255})
+	if differing, first, err := gamuttest.Diff(a, b, 0); err != nil || differing != 1 || first != image.Pt(1, 2) {
+		t.Errorf("got %d differing, first one at %v, %v", differing, first, err)
+	}
+	if differing, _, _ := gamuttest.Diff(a, b, 3); differing != 0 {
+		t.Errorf("got %d differing within the tolerance", differing)
+	}
+	// Images are compared from their top left corners
+	if differing, _, err := gamuttest.Diff(a.SubImage(image.Rect(2, 2, 4, 4)), b.SubImage(image.Rect(0, 0, 2, 2)), 0); err != nil || differing != 0 {
+		t.Errorf("got %d differing, %v", differing, err)
+	}
+	if _, _, err := gamuttest.Diff(a, gamuttest.Solid(color.Black, 4, 5), 0); err == nil {
+		t.Error("images of different size compared")
+	}
+}
+
+func TestCompareGolden(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gamuttest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "testdata", "solid.golden.png")
+	img := gamuttest.Solid(color.RGBA{10, 200, 30, 255}, 4, 4)
+
+	if failures := record(t, func(tb testing.TB) { gamuttest.CompareGolden(tb, fileName, img, 0) }); len(failures) != 1 {
+		t.Errorf("got %v comparing with a missing golden file", failures)
+	}
+
+	gamuttest.Update = true
+	failures := record(t, func(tb testing.TB) { gamuttest.CompareGolden(tb, fileName, img, 0) })
+	gamuttest.Update = false
+	if len(failures) > 0 {
+		t.Fatalf("got %v updating", failures)
+	}
+	if failures := record(t, func(tb testing.TB) { gamuttest.CompareGolden(tb, fileName, img, 0) }); len(failures) > 0 {
+		t.Errorf("got %v comparing with the updated golden file", failures)
+	}
+
+	other := gamuttest.Solid(color.RGBA{10, 200, 30, 255}, 4, 4)
+	other.Set(3, 3, color.RGBA{20, 200, 30, 255})
+	if failures := record(t, func(tb testing.TB) { gamuttest.CompareGolden(tb, fileName, other, 0) }); len(failures) != 1 {
+		t.Errorf("got %v comparing a different image", failures)
+	}
+	if failures := record(t, func(tb testing.TB) { gamuttest.CompareGolden(tb, fileName, other, 10) }); len(failures) > 0 {
+		t.Errorf("got %v comparing within the tolerance", failures)
+	}
+
+	if got := gamuttest.GoldenPath("solid"); got != filepath.Join("testdata", "solid.golden.png") {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestLanding(t *testing.T) {
+	opts := lib.DefaultOptions
+	red := color.RGBA{255, 0, 0, 255}
+	if failures := record(t, func(tb testing.TB) { gamuttest.ExpectLandsAt(tb, opts, red, image.Pt(125, 2), 0) }); len(failures) > 0 {
+		t.Errorf("got %v", failures)
+	}
+	if failures := record(t, func(tb testing.TB) { gamuttest.ExpectLandsAt(tb, opts, red, image.Pt(125, 5), 1) }); len(failures) != 1 {
+		t.Errorf("got %v landing 3 pixels away", failures)
+	}
+
+	wheel, err := lib.GenerateGamutMaskWithOptions(gamuttest.Solid(red, 4, 4), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	background, err := gamuttest.Background(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures := record(t, func(tb testing.TB) {
+		gamuttest.ExpectDrawn(tb, wheel, opts, red)
+		gamuttest.ExpectOnlyAt(tb, wheel, background, image.Pt(125, 2))
+	}); len(failures) > 0 {
+		t.Errorf("got %v", failures)
+	}
+	if failures := record(t, func(tb testing.TB) {
+		gamuttest.ExpectDrawn(tb, wheel, opts, color.RGBA{0, 0, 255, 255})
+		gamuttest.ExpectOnlyAt(tb, wheel, background)
+	}); len(failures) != 2 {
+		t.Errorf("got %v for a color not drawn", failures)
+	}
+}
@@ -0,0 +1,93 @@
+package gamuttest
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Update makes CompareGolden write the images it gets as the new golden files instead of comparing.
+// It is set when the GAMUTTEST_UPDATE environment variable is not empty.
+var Update = os.Getenv("GAMUTTEST_UPDATE") != ""
+
+// GoldenPath returns the conventional location of the golden file called name: testdata/<name>.golden.png
+func GoldenPath(name string) string {
+	return filepath.Join("testdata", name+".golden.png")
+}
+
+// CompareGolden fails t if got differs from the PNG image stored in fileName in size or by more than
+// tolerance in any of the 8-bit components of any pixel.
+//
+// With GAMUTTEST_UPDATE=1 set for go test (or Update set), got is written to fileName instead
+// (creating the folder if necessary), which is how golden files are created and updated.
+func CompareGolden(t testing.TB, fileName string, got image.Image, tolerance uint8) {
+	t.Helper()
+	if Update {
+		if err := writePNG(fileName, got); err != nil {
+			t.Fatalf("can't update golden file: %v", err)
+		}
+		return
+	}
+	want, err := readPNG(fileName)
+	if err != nil {
+		t.Fatalf("can't read golden file (run with GAMUTTEST_UPDATE=1 to create it): %v", err)
+	}
+	differing, first, err := Diff(got, want, tolerance)
+	if err != nil {
+		t.Errorf("%v: %v", fileName, err)
+		return
+	}
+	if differing > 0 {
+		t.Errorf("%v: %d pixels differ by more than %d, first one at %v: got %v, want %v",
+			fileName, differing, tolerance, first, got.At(first.X, first.Y), want.At(first.X, first.Y))
+	}
+}
+
+// Diff returns the amount of pixels of a and b that differ by more than tolerance in any of the 8-bit components
+// along with the first one of them. Returns an error if the images are of different size.
+func Diff(a, b image.Image, tolerance uint8) (differing int, first image.Point, err error) {
+	ab, bb := a.Bounds(), b.Bounds()
+	if ab.Size() != bb.Size() {
+		return 0, first, fmt.Errorf("size %v differs from %v", ab.Size(), bb.Size())
+	}
+	// Comparing 16-bit components with the tolerance scaled up the same way 8-bit components are
+	tolerance16 := uint32(tolerance) * 0x101
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			if !sameColor(a.At(ab.Min.X+x, ab.Min.Y+y), b.At(bb.Min.X+x, bb.Min.Y+y), tolerance16) {
+				if differing == 0 {
+					first = image.Point{ab.Min.X + x, ab.Min.Y + y}
+				}
+				differing++
+			}
+		}
+	}
+	return differing, first, nil
+}
+
+func readPNG(fileName string) (image.Image, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+func writePNG(fileName string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,66 @@
+// Package gamuttest provides images of known colors and helpers to test projections,
+// renderers and anything else built on top of the lib package.
+package gamuttest
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// Solid returns an image of width by height filled with c
+func Solid(c color.Color, width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	nrgba := color.NRGBAModel.Convert(c).(color.NRGBA)
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = nrgba.R, nrgba.G, nrgba.B, nrgba.A
+	}
+	return img
+}
+
+// HueRamp returns an image of fully saturated and bright colors with the hue going
+// from 0 (red) on the left to almost 360 on the right
+func HueRamp(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		c := colorful.Hsv(360*float64(x)/float64(width), 1, 1).Clamped()
+		for y := 0; y < height; y++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// Grayscale returns an image of grays going from black on the left to white on the right
+func Grayscale(width, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		gray := color.Gray{Y: uint8(255 * x / maxInt(width-1, 1))}
+		for y := 0; y < height; y++ {
+			img.SetGray(x, y, gray)
+		}
+	}
+	return img
+}
+
+// HSVSweep returns an image of colors of the given HSV value (from 0 to 1) with the hue going
+// from 0 on the left to almost 360 on the right and the saturation going from 1 at the top to 0
+// at the bottom, covering the whole wheel of that value
+func HSVSweep(width, height int, value float64) *image.NRGBA64 {
+	img := image.NewNRGBA64(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			saturation := 1 - float64(y)/float64(maxInt(height-1, 1))
+			img.Set(x, y, colorful.Hsv(360*float64(x)/float64(width), saturation, value).Clamped())
+		}
+	}
+	return img
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
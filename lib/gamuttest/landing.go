@@ -0,0 +1,91 @@
+package gamuttest
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/zzwx/gamutmask/lib"
+)
+
+// ExpectLandsAt fails t unless c lands within tolerance pixels (in both directions)
+// from want on the canvas described by opts
+func ExpectLandsAt(t testing.TB, opts lib.Options, c color.Color, want image.Point, tolerance int) {
+	t.Helper()
+	got, ok := opts.PointOf(c)
+	if !ok {
+		t.Errorf("%v lands outside of the canvas, want %v", c, want)
+		return
+	}
+	if abs(got.X-want.X) > tolerance || abs(got.Y-want.Y) > tolerance {
+		t.Errorf("%v lands at %v, want %v±%d", c, got, want, tolerance)
+	}
+}
+
+// ExpectDrawn fails t unless wheel, rendered with opts by BrightestRenderer, shows c
+// where c lands. Use it to check a color has won its spot.
+func ExpectDrawn(t testing.TB, wheel image.Image, opts lib.Options, c color.Color) {
+	t.Helper()
+	p, ok := opts.PointOf(c)
+	if !ok {
+		t.Errorf("%v lands outside of the canvas", c)
+		return
+	}
+	r, g, b, _ := c.RGBA()
+	gr, gg, gb, _ := wheel.At(p.X, p.Y).RGBA()
+	if r != gr || g != gg || b != gb {
+		t.Errorf("%v lands at %v, but %v is drawn there", c, p, wheel.At(p.X, p.Y))
+	}
+}
+
+// ExpectOnlyAt fails t if any pixel of wheel other than points differs from the background
+// image (as rendered for an empty accumulator with the same options)
+func ExpectOnlyAt(t testing.TB, wheel image.Image, background image.Image, points ...image.Point) {
+	t.Helper()
+	allowed := make(map[image.Point]bool, len(points))
+	for _, p := range points {
+		allowed[p] = true
+	}
+	bounds := wheel.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if allowed[image.Point{x, y}] {
+				continue
+			}
+			if !sameColor(wheel.At(x, y), background.At(x, y), 0) {
+				t.Errorf("unexpected %v drawn at %v", wheel.At(x, y), image.Point{x, y})
+				return
+			}
+		}
+	}
+}
+
+// Background renders the wheel of an empty accumulator with opts
+func Background(opts lib.Options) (*image.RGBA64, error) {
+	accumulator, err := lib.NewGamutAccumulatorWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return accumulator.Render(), nil
+}
+
+func sameColor(a, b color.Color, tolerance uint32) bool {
+	ar, ag, ab, aa := a.RGBA()
+	br, bg, bb, ba := b.RGBA()
+	return diff(ar, br) <= tolerance && diff(ag, bg) <= tolerance &&
+		diff(ab, bb) <= tolerance && diff(aa, ba) <= tolerance
+}
+
+func diff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
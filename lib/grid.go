@@ -0,0 +1,90 @@
+package lib
+
+// Bin keeps the statistics of all the samples that landed on one spot of the canvas
+type Bin struct {
+	Count            uint64  // Amount of samples
+	SumR, SumG, SumB uint64  // Sums of the 16-bit components of the samples
+	MinKey, MaxKey   float64 // Lowest and highest keys of the samples as returned by Projection
+	R, G, B          uint16  // Color of the sample with the highest key
+}
+
+// Grid is the canvas split into Bins, one per pixel
+type Grid struct {
+	Width, Height int
+	Bins          []Bin // Row by row
+}
+
+// NewGrid creates a grid of empty bins
+func NewGrid(width, height int) *Grid {
+	return &Grid{
+		Width:  width,
+		Height: height,
+		Bins:   make([]Bin, width*height),
+	}
+}
+
+// At returns the bin at x, y of the canvas
+func (g *Grid) At(x, y int) *Bin {
+	return &g.Bins[y*g.Width+x]
+}
+
+// Merge adds all the samples of other into the grid of the same size
+func (g *Grid) Merge(other *Grid) {
+	for i := range other.Bins {
+		g.Bins[i].Merge(&other.Bins[i])
+	}
+}
+
+// Add puts a sample into the bin
+func (b *Bin) Add(r, g, bl uint16, key float64) {
+	if b.Count == 0 {
+		*b = Bin{
+			Count: 1,
+			SumR:  uint64(r), SumG: uint64(g), SumB: uint64(bl),
+			MinKey: key, MaxKey: key,
+			R: r, G: g, B: bl,
+		}
+		return
+	}
+	b.Count++
+	b.SumR += uint64(r)
+	b.SumG += uint64(g)
+	b.SumB += uint64(bl)
+	if key < b.MinKey {
+		b.MinKey = key
+	}
+	if b.MaxKey < key || (b.MaxKey == key && rgbOrder(r, g, bl) > rgbOrder(b.R, b.G, b.B)) {
+		b.MaxKey = key
+		b.R, b.G, b.B = r, g, bl
+	}
+}
+
+// Merge adds all the samples of other into the bin.
+//
+// Of the samples with the same highest key, the color with the highest RGB value is kept,
+// so the result doesn't depend on the order the samples are added or merged in.
+func (b *Bin) Merge(other *Bin) {
+	if other.Count == 0 {
+		return
+	}
+	if b.Count == 0 {
+		*b = *other
+		return
+	}
+	b.Count += other.Count
+	b.SumR += other.SumR
+	b.SumG += other.SumG
+	b.SumB += other.SumB
+	if other.MinKey < b.MinKey {
+		b.MinKey = other.MinKey
+	}
+	if b.MaxKey < other.MaxKey || (b.MaxKey == other.MaxKey &&
+		rgbOrder(other.R, other.G, other.B) > rgbOrder(b.R, b.G, b.B)) {
+		b.MaxKey = other.MaxKey
+		b.R, b.G, b.B = other.R, other.G, other.B
+	}
+}
+
+func rgbOrder(r, g, b uint16) uint64 {
+	return uint64(r)<<32 | uint64(g)<<16 | uint64(b)
+}
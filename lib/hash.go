@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Hasher computes hashes of files to find out if they have changed
+type Hasher struct {
+	Name string // Recorded in the manifest along with every hash
+	New  func() hash.Hash
+}
+
+var (
+	// MD5Hasher is the hasher used by default
+	MD5Hasher = Hasher{Name: "md5", New: md5.New}
+	// SHA256Hasher is the hasher to use when collisions matter more than speed
+	SHA256Hasher = Hasher{Name: "sha256", New: sha256.New}
+	// XXHasher is a non-cryptographic hasher, many times faster than MD5
+	XXHasher = Hasher{Name: "xxhash", New: func() hash.Hash { return xxhash.New() }}
+)
+
+var hashers = map[string]Hasher{
+	MD5Hasher.Name:    MD5Hasher,
+	SHA256Hasher.Name: SHA256Hasher,
+	XXHasher.Name:     XXHasher,
+}
+
+// HasherByName returns the hasher with the name, like "md5", "sha256" or "xxhash"
+func HasherByName(name string) (Hasher, error) {
+	hasher, ok := hashers[name]
+	if !ok {
+		return Hasher{}, fmt.Errorf("unknown hasher %q", name)
+	}
+	return hasher, nil
+}
+
+// HasherNames returns the names of all the hashers, sorted
+func HasherNames() []string {
+	names := make([]string, 0, len(hashers))
+	for name := range hashers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HashFile will open the file, calculate and return its hash as a sequence of Hex symbols
+func HashFile(fileName string, hasher Hasher) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", fmt.Errorf("can't open file to hash: %w", err)
+	}
+	defer file.Close()
+	hash := hasher.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("can't read file to hash: %w", err)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
@@ -0,0 +1,275 @@
+package lib
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+)
+
+const (
+	// HistogramSize is the width and height of the canvas histograms of images are kept at by RenderFile
+	HistogramSize = 1024
+	// HistogramPadding is the padding of the canvas histograms of images are kept at by RenderFile
+	HistogramPadding = 4
+)
+
+// HistogramOptions returns opts with the size and padding of the canvas histograms of images are kept at
+func HistogramOptions(opts Options) Options {
+	opts.Width, opts.Height = HistogramSize, HistogramSize
+	opts.PaddingX, opts.PaddingY = HistogramPadding, HistogramPadding
+	return opts
+}
+
+// histogramMagic starts every histogram file, followed by the version
+const histogramMagic = "GMHS"
+
+// histogramVersion is bumped every time the layout of histogram files changes.
+// Histograms of other versions are replaced.
+const histogramVersion = 3
+
+// histogram is the compact form of an accumulator of HistogramSize kept by RenderFile: only the amount of samples,
+// the average color (reduced to 8 bits) and the color with the highest key of every non-empty bin. Where the bins
+// were doesn't matter, as they are placed where their color with the highest key lands. That color is kept
+// with its 16-bit components, as colors moved by a fraction of a pixel already win other spots of sparse wheels.
+// The colors counted by the accumulator are kept reduced to 8 bits.
+type histogram struct {
+	bins   []histogramBin
+	colors *ColorCounts // Optional
+}
+
+type histogramBin struct {
+	count   uint64
+	average [3]uint8
+	color   [3]uint16
+}
+
+// newHistogram returns the compact form of the bins of a
+func newHistogram(a *GamutAccumulator) *histogram {
+	h := &histogram{}
+	if a.colors != nil {
+		h.colors = NewColorCounts()
+		a.colors.Each(func(r, g, b uint16, n uint64) {
+			h.colors.Add((r>>8)*0x101, (g>>8)*0x101, (b>>8)*0x101, n)
+		})
+	}
+	for i := range a.grid.Bins {
+		bin := &a.grid.Bins[i]
+		if bin.Count == 0 {
+			continue
+		}
+		h.bins = append(h.bins, histogramBin{
+			count: bin.Count,
+			average: [3]uint8{
+				uint8(bin.SumR / bin.Count >> 8),
+				uint8(bin.SumG / bin.Count >> 8),
+				uint8(bin.SumB / bin.Count >> 8),
+			},
+			color: [3]uint16{bin.R, bin.G, bin.B},
+		})
+	}
+	return h
+}
+
+// resample returns an accumulator for opts with the bins of the histogram placed where their color lands,
+// like GamutAccumulator.Resample does. The lowest keys of the bins are not kept.
+func (h *histogram) resample(opts Options) (*GamutAccumulator, error) {
+	resampled, err := NewGamutAccumulatorWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, bin := range h.bins {
+		r, g, b := uint32(bin.color[0]), uint32(bin.color[1]), uint32(bin.color[2])
+		p, _, _, key, ok := opts.place(resampled.projection, r, g, b)
+		if !ok {
+			continue
+		}
+		resampled.grid.At(p.X, p.Y).Merge(&Bin{
+			Count:  bin.count,
+			SumR:   uint64(bin.average[0]) * 0x101 * bin.count,
+			SumG:   uint64(bin.average[1]) * 0x101 * bin.count,
+			SumB:   uint64(bin.average[2]) * 0x101 * bin.count,
+			MinKey: key, MaxKey: key,
+			R: uint16(r), G: uint16(g), B: uint16(b),
+		})
+	}
+	return resampled, nil
+}
+
+// writeTo writes the histogram as the magic, the version, the amount of bins and then the bins, every one of them
+// as the amount of samples (uvarint) followed by the average color and the color with the highest key
+// (little endian).
+// Then a byte tells if colors are counted, followed by the amount of colors (uvarint) and the colors,
+// every one of them as the color followed by the amount of samples (uvarint).
+func (h *histogram) writeTo(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(histogramMagic)
+	bw.WriteByte(histogramVersion)
+	var buf [binary.MaxVarintLen64 + 9]byte
+	bw.Write(buf[:binary.PutUvarint(buf[:], uint64(len(h.bins)))])
+	for _, bin := range h.bins {
+		n := binary.PutUvarint(buf[:], bin.count)
+		n += copy(buf[n:], bin.average[:])
+		for _, c := range bin.color {
+			binary.LittleEndian.PutUint16(buf[n:], c)
+			n += 2
+		}
+		bw.Write(buf[:n])
+	}
+	if h.colors == nil {
+		bw.WriteByte(0)
+		return bw.Flush()
+	}
+	bw.WriteByte(1)
+	bw.Write(buf[:binary.PutUvarint(buf[:], uint64(h.colors.Len()))])
+	h.colors.Each(func(r, g, b uint16, count uint64) {
+		n := copy(buf[:], []byte{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)})
+		n += binary.PutUvarint(buf[n:], count)
+		bw.Write(buf[:n])
+	})
+	return bw.Flush()
+}
+
+// errBrokenHistogram is returned for histogram files that can't be read, which are simply replaced
+var errBrokenHistogram = errors.New("broken histogram")
+
+func readHistogram(r io.Reader) (*histogram, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(histogramMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil || string(header) != histogramMagic+string(rune(histogramVersion)) {
+		return nil, errBrokenHistogram
+	}
+	bins, err := binary.ReadUvarint(br)
+	if err != nil || bins > HistogramSize*HistogramSize {
+		return nil, errBrokenHistogram
+	}
+	h := &histogram{bins: make([]histogramBin, bins)}
+	for i := range h.bins {
+		bin := &h.bins[i]
+		if bin.count, err = binary.ReadUvarint(br); err != nil {
+			return nil, errBrokenHistogram
+		}
+		var colors [9]byte
+		if _, err := io.ReadFull(br, colors[:]); err != nil {
+			return nil, errBrokenHistogram
+		}
+		copy(bin.average[:], colors[:3])
+		for k := range bin.color {
+			bin.color[k] = binary.LittleEndian.Uint16(colors[3+k*2:])
+		}
+	}
+	counted, err := br.ReadByte()
+	if err != nil {
+		return nil, errBrokenHistogram
+	}
+	if counted == 0 {
+		return h, nil
+	}
+	colors, err := binary.ReadUvarint(br)
+	if err != nil || colors > 1<<24 {
+		return nil, errBrokenHistogram
+	}
+	h.colors = NewColorCounts()
+	for i := uint64(0); i < colors; i++ {
+		var color [3]byte
+		if _, err := io.ReadFull(br, color[:]); err != nil {
+			return nil, errBrokenHistogram
+		}
+		count, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, errBrokenHistogram
+		}
+		h.colors.Add(uint16(color[0])*0x101, uint16(color[1])*0x101, uint16(color[2])*0x101, count)
+	}
+	return h, nil
+}
+
+// renderHistogram renders the wheel of img, stored in inputFileName, out of its histogram kept in histogramFileName,
+// which is replaced with a new one unless it is newer than the image
+func renderHistogram(ctx context.Context, img limitedImage, inputFileName, histogramFileName string, settings *RunGamutSettings) (wheel *image.RGBA64, info RenderInfo, err error) {
+	histogram := loadHistogram(inputFileName, histogramFileName)
+	if histogram != nil && settings.Munsell != nil && histogram.colors == nil {
+		histogram = nil // Kept without the colors to summarize
+	}
+	if histogram != nil {
+		info = img.info
+		if info.Format == "" { // Not decoded by settings.limit without limits
+			config, format, err := image.DecodeConfig(img.r)
+			if err != nil {
+				return nil, info, fmt.Errorf("can't decode image: %w", err)
+			}
+			info = RenderInfo{Format: format, Bounds: image.Rect(0, 0, config.Width, config.Height)}
+		}
+		info.Cached = true
+		settings.report(StageDecode, 1)
+		settings.report(StageGenerate, 0)
+	} else {
+		var accumulators []*GamutAccumulator
+		if accumulators, info, err = collect(ctx, img, true, settings); err != nil {
+			return nil, info, err
+		}
+		// Rendering out of the compact form of the histogram, so the wheel is the same once it is kept
+		histogram = newHistogram(accumulators[0])
+		if err := saveHistogram(histogramFileName, histogram); err != nil {
+			os.Remove(histogramFileName) // A partially written histogram would be read back as a broken one
+			return nil, info, err
+		}
+	}
+
+	info.Munsell = settings.summarize(histogram.colors)
+	accumulator, err := histogram.resample(settings.Options)
+	if err != nil {
+		return nil, info, fmt.Errorf("gamut mask couldn't be generated: %w", err)
+	}
+	settings.report(StageGenerate, 1)
+	return accumulator.Render(), info, nil
+}
+
+// loadHistogram returns the histogram kept in histogramFileName if it is newer than the image, or nil
+func loadHistogram(inputFileName, histogramFileName string) *histogram {
+	input, err := os.Stat(inputFileName)
+	if err != nil {
+		return nil
+	}
+	stat, err := os.Stat(histogramFileName)
+	if err != nil || stat.ModTime().Before(input.ModTime()) {
+		return nil
+	}
+	file, err := os.Open(histogramFileName)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	r, err := gzip.NewReader(file)
+	if err != nil {
+		return nil // Broken histograms are simply replaced
+	}
+	h, err := readHistogram(r)
+	if err != nil {
+		return nil
+	}
+	return h
+}
+
+// saveHistogram writes the histogram into histogramFileName compressed with gzip
+func saveHistogram(histogramFileName string, h *histogram) error {
+	out, err := os.Create(histogramFileName)
+	if err != nil {
+		return fmt.Errorf("can't create histogram file: %w", err)
+	}
+	w, _ := gzip.NewWriterLevel(out, gzip.BestSpeed)
+	if err := h.writeTo(w); err != nil {
+		out.Close()
+		return fmt.Errorf("can't write histogram file: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		out.Close()
+		return fmt.Errorf("can't write histogram file: %w", err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,154 @@
+package lib_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zzwx/gamutmask/lib"
+	"github.com/zzwx/gamutmask/lib/gamuttest"
+)
+
+// writePhoto saves a generated photo of width by height as JPEG into dir
+func writePhoto(t testing.TB, dir string, width, height int) string {
+	t.Helper()
+	fileName := filepath.Join(dir, "photo.jpg")
+	out, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if err := jpeg.Encode(out, gamuttest.Photo(width, height), &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestRenderFileHistogram(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	input := writePhoto(t, dir, 300, 200)
+	settings := lib.RunGamutSettings{Options: lib.DefaultOptions}
+	settings.HistogramFileName = func(inputFileName string) string {
+		return inputFileName + ".gamuthist"
+	}
+
+	first, info := renderFile(t, input, settings)
+	if info.Cached {
+		t.Error("rendered out of a histogram that wasn't kept yet")
+	}
+	cached, info := renderFile(t, input, settings)
+	if !info.Cached || info.Format != "jpeg" || info.Bounds != image.Rect(0, 0, 300, 200) {
+		t.Errorf("got %+v, want a cached 300x200 jpeg", info)
+	}
+	if differing, first, _ := gamuttest.Diff(first, cached, 0); differing > 0 {
+		t.Errorf("%d pixels differ, first one at %v", differing, first)
+	}
+
+	// Rendering at another size, close to rendering the image itself at that size
+	settings.Width, settings.Height = 120, 120
+	resampled, info := renderFile(t, input, settings)
+	if !info.Cached {
+		t.Error("not rendered out of the histogram")
+	}
+	img, _, err := lib.DecodeFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := lib.GenerateGamutMaskWithOptions(img, settings.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if differing, _, _ := gamuttest.Diff(decoded, resampled, 16); differing > 20 {
+		t.Errorf("%d pixels differ from the wheel of the image", differing)
+	}
+
+	// A changed image has its histogram replaced
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(input, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, info := renderFile(t, input, settings); info.Cached {
+		t.Error("rendered out of the histogram of an older image")
+	}
+}
+
+func TestRenderFileHistogramCompact(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	input := writePhoto(t, dir, 300, 200)
+	histogramFileName := input + ".gamuthist"
+	settings := lib.RunGamutSettings{Options: lib.DefaultOptions}
+	settings.HistogramFileName = func(inputFileName string) string {
+		return histogramFileName
+	}
+
+	// Broken histograms are replaced
+	if err := ioutil.WriteFile(histogramFileName, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, info := renderFile(t, input, settings); info.Cached {
+		t.Error("rendered out of a broken histogram")
+	}
+	if _, info := renderFile(t, input, settings); !info.Cached {
+		t.Error("broken histogram not replaced")
+	}
+
+	// Smaller than the whole accumulator compressed the same way
+	stat, err := os.Stat(histogramFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _, err := lib.DecodeFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	if _, err := accumulate(t, lib.HistogramOptions(lib.DefaultOptions), img).WriteTo(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if stat.Size()*2 > int64(buf.Len()) {
+		t.Errorf("histogram takes %d bytes, the accumulator %d", stat.Size(), buf.Len())
+	}
+}
+
+// BenchmarkRenderFile compares rendering a 3MP photo by decoding it with rendering it out of its histogram
+func BenchmarkRenderFile(b *testing.B) {
+	dir, remove := tempDir(b)
+	defer remove()
+	input := writePhoto(b, dir, 2000, 1500)
+	output := filepath.Join(dir, "photo.png")
+	for _, cached := range []bool{false, true} {
+		settings := lib.RunGamutSettings{Options: lib.DefaultOptions}
+		name := "decode"
+		if cached {
+			name = "cached"
+			settings.HistogramFileName = func(inputFileName string) string {
+				return inputFileName + ".gamuthist"
+			}
+			if _, err := lib.RenderFile(context.Background(), input, output, &settings); err != nil {
+				b.Fatal(err) // Keeping the histogram
+			}
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				info, err := lib.RenderFile(context.Background(), input, output, &settings)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if info.Cached != cached {
+					b.Fatalf("got cached %v, want %v", info.Cached, cached)
+				}
+			}
+		})
+	}
+}
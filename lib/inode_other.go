@@ -0,0 +1,11 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package lib
+
+import "os"
+
+// inode returns 0 as inode numbers are not available on this platform
+func inode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -1,62 +1,39 @@
 package lib
 
 import (
+	"context"
 	"image"
-	"image/color"
-	"math"
-
-	"github.com/fogleman/gg"
-	"github.com/lucasb-eyer/go-colorful"
 )
 
 // GenerateGamutMask generates a wheel (as *image.RGBA64) of Gamut Mask with a size of maskWidth, maskHeight
+// Of the colors landing on the same spot with the same value, the one with the highest RGB components is drawn.
 func GenerateGamutMask(img image.Image, maskWidth, maskHeight, paddingX, paddingY int) (wheel *image.RGBA64) {
-	bounds := img.Bounds()
-
-	wheel = image.NewRGBA64(image.Rect(0, 0, maskWidth, maskHeight))
-
-	context := gg.NewContext(maskWidth, maskHeight)
-	context.DrawEllipse(float64(maskWidth)/2, float64(maskHeight)/2, float64(maskWidth)/2, float64(maskHeight)/2)
-	context.SetRGB(0, 0, 0)
-	context.Fill()
-	// context.SavePNG(fmt.Sprintf("test_%d.png", time.Now().Nanosecond()))
-
-	for x := 0; x < maskWidth; x++ {
-		for y := 0; y < maskHeight; y++ {
-			r, g, b, a := context.Image().At(x, y).RGBA()
-			wheel.SetRGBA64(int(x), int(y),
-				color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)})
-			//wheel.SetRGBA(x, y, color.RGBA{uint8(r), uint8(g), uint8(b), uint8(a)})
-		}
-	}
-
-	// Fill with black background
-	//draw.Draw(wheel, bounds, &image.Uniform{color.RGBA{0, 0, 0, 255}}, image.ZP, draw.Src)
-
-	for x := bounds.Min.X; x < bounds.Max.X; x++ {
-		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-			r, g, b, _ := img.At(x, y).RGBA()
-			h, s, v := hsv(r, g, b)
-			// Rotating by -math.Pi/2 so Red appears on top
-			x := math.Cos(h*math.Pi/180-math.Pi/2)*s*float64(maskWidth-paddingX*2)/2.0 + float64(maskWidth)/2.0
-			y := math.Sin(h*math.Pi/180-math.Pi/2)*s*float64(maskHeight-paddingY*2)/2.0 + float64(maskHeight)/2.0
+	accumulator := NewGamutAccumulator(maskWidth, maskHeight, paddingX, paddingY)
+	accumulator.Add(img)
+	return accumulator.Render()
+}
 
-			current := wheel.RGBA64At(int(x), int(y))
-			_, _, currentV := hsv(uint32(current.R), uint32(current.G), uint32(current.B))
-			if currentV < v {
-				wheel.SetRGBA64(int(x), int(y),
-					color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(0xFFFF)})
-			}
-		}
+// GenerateGamutMaskWithOptions generates a wheel (as *image.RGBA64) of Gamut Mask described by opts.
+// Returns an *OptionsError if opts are invalid.
+func GenerateGamutMaskWithOptions(img image.Image, opts Options) (wheel *image.RGBA64, err error) {
+	accumulator, err := NewGamutAccumulatorWithOptions(opts)
+	if err != nil {
+		return nil, err
 	}
-	return wheel
+	accumulator.Add(img)
+	return accumulator.Render(), nil
 }
 
-func hsv(r, g, b uint32) (h, s, v float64) {
-	c := colorful.Color{
-		R: float64(r) / float64(0xFFFF),
-		G: float64(g) / float64(0xFFFF),
-		B: float64(b) / float64(0xFFFF)}
-	h, s, v = c.Hsv()
-	return
+// GenerateGamutMaskContext is GenerateGamutMaskWithOptions that can be stopped through ctx.
+// It reports the portion of img done (from 0 to 1) to the optional progress function every few rows.
+// Once ctx is done, it stops with ctx.Err() and no wheel.
+func GenerateGamutMaskContext(ctx context.Context, img image.Image, opts Options, progress func(done float64)) (wheel *image.RGBA64, err error) {
+	accumulator, err := NewGamutAccumulatorWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := accumulator.AddContext(ctx, img, progress); err != nil {
+		return nil, err
+	}
+	return accumulator.Render(), nil
 }
@@ -0,0 +1,68 @@
+package lib_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/zzwx/gamutmask/lib"
+	"github.com/zzwx/gamutmask/lib/gamuttest"
+)
+
+// TestHSVLandsAt checks the hue is the angle (red on top going clockwise) and the saturation the distance
+// from the center of the default 250x250 wheel with a radius of 123 pixels
+func TestHSVLandsAt(t *testing.T) {
+	opts := lib.DefaultOptions
+	for _, test := range []struct {
+		color color.Color
+		want  image.Point
+	}{
+		{color.RGBA{255, 0, 0, 255}, image.Pt(125, 2)},       // Red on top
+		{color.RGBA{255, 255, 0, 255}, image.Pt(231, 63)},    // Yellow at 60°
+		{color.RGBA{0, 255, 0, 255}, image.Pt(231, 186)},     // Green at 120°
+		{color.RGBA{0, 0, 255, 255}, image.Pt(18, 186)},      // Blue at 240°
+		{color.RGBA{255, 128, 128, 255}, image.Pt(125, 63)},  // Half saturated red halfway to the center
+		{color.RGBA{128, 128, 128, 255}, image.Pt(125, 125)}, // Grays in the center
+		{color.RGBA{0, 0, 0, 255}, image.Pt(125, 125)},
+	} {
+		gamuttest.ExpectLandsAt(t, opts, test.color, test.want, 1)
+	}
+}
+
+func TestGenerateGamutMaskDrawsSolid(t *testing.T) {
+	for _, c := range []color.Color{
+		color.RGBA{255, 0, 0, 255},
+		color.RGBA{30, 200, 90, 255},
+		color.RGBA{10, 20, 160, 255},
+	} {
+		wheel, err := lib.GenerateGamutMaskWithOptions(gamuttest.Solid(c, 8, 8), lib.DefaultOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gamuttest.ExpectDrawn(t, wheel, lib.DefaultOptions, c)
+		p, _ := lib.DefaultOptions.PointOf(c)
+		background, err := gamuttest.Background(lib.DefaultOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gamuttest.ExpectOnlyAt(t, wheel, background, p)
+	}
+}
+
+// TestGenerateGamutMaskHueRamp keeps the wheel of the fully saturated hues in testdata/hue-ramp.golden.png
+func TestGenerateGamutMaskHueRamp(t *testing.T) {
+	wheel, err := lib.GenerateGamutMaskWithOptions(gamuttest.HueRamp(360, 4), lib.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gamuttest.CompareGolden(t, gamuttest.GoldenPath("hue-ramp"), wheel, 0)
+}
+
+// TestGenerateGamutMaskHSVSweep keeps the wheel covered by the colors of a value in testdata/hsv-sweep.golden.png
+func TestGenerateGamutMaskHSVSweep(t *testing.T) {
+	wheel, err := lib.GenerateGamutMaskWithOptions(gamuttest.HSVSweep(360, 124, 0.8), lib.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gamuttest.CompareGolden(t, gamuttest.GoldenPath("hsv-sweep"), wheel, 0)
+}
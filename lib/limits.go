@@ -0,0 +1,126 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Limits protect from decoding images too large to handle. They are checked against
+// image.DecodeConfig before an image is decoded. Zero values mean no limit.
+type Limits struct {
+	MaxPixels int64 // Images with more pixels are skipped, or sampled with SampleOversized
+	MaxMemory int64 // Images estimated to take more bytes once decoded are always skipped, unless they are streamed
+
+	// SampleOversized projects at most MaxPixels samples of images with more pixels instead of skipping them
+	SampleOversized bool
+}
+
+// String describes the limits, for ProcessOptions.SkipKey
+func (l Limits) String() string {
+	if l == (Limits{}) {
+		return ""
+	}
+	s := fmt.Sprintf("maxPixels=%d maxMemory=%d", l.MaxPixels, l.MaxMemory)
+	if l.SampleOversized {
+		s += " sample"
+	}
+	return s
+}
+
+var (
+	// ErrTooManyPixels is wrapped by a SkipError for images with more than Limits.MaxPixels pixels
+	ErrTooManyPixels = errors.New("image has too many pixels")
+	// ErrTooMuchMemory is wrapped by a SkipError for images estimated to take more than Limits.MaxMemory bytes
+	ErrTooMuchMemory = errors.New("image would take too much memory to decode")
+)
+
+// check returns the sampling to render an image of config with, or a *SkipError if the image has too many pixels
+func (l Limits) check(config image.Config, sampling Sampling) (Sampling, error) {
+	if pixels := int64(config.Width) * int64(config.Height); l.MaxPixels > 0 && pixels > l.MaxPixels {
+		if !l.SampleOversized {
+			return sampling, &SkipError{Err: fmt.Errorf("%w: %dx%d is more than %d",
+				ErrTooManyPixels, config.Width, config.Height, l.MaxPixels)}
+		}
+		if sampling.MaxSamples == 0 || int64(sampling.MaxSamples) > l.MaxPixels {
+			sampling.MaxSamples = int(l.MaxPixels)
+		}
+	}
+	return sampling, nil
+}
+
+// checkMemory returns a *SkipError if an image of config would take more memory than allowed once decoded
+func (l Limits) checkMemory(config image.Config) error {
+	if l.MaxMemory > 0 {
+		if size := decodedSize(config); size > l.MaxMemory {
+			return &SkipError{Err: fmt.Errorf("%w: %dx%d needs about %dMB",
+				ErrTooMuchMemory, config.Width, config.Height, size>>20)}
+		}
+	}
+	return nil
+}
+
+// decodedSize estimates the amount of bytes an image of config takes once decoded
+func decodedSize(config image.Config) int64 {
+	bytesPerPixel := int64(8)
+	switch config.ColorModel {
+	case color.GrayModel, color.AlphaModel:
+		bytesPerPixel = 1
+	case color.Gray16Model, color.Alpha16Model:
+		bytesPerPixel = 2
+	case color.YCbCrModel:
+		bytesPerPixel = 3 // Without chroma subsampling, which DecodeConfig doesn't tell about
+	case color.RGBAModel, color.NRGBAModel, color.CMYKModel:
+		bytesPerPixel = 4
+	default:
+		if _, ok := config.ColorModel.(color.Palette); ok {
+			bytesPerPixel = 1
+		}
+	}
+	return int64(config.Width) * int64(config.Height) * bytesPerPixel
+}
+
+// limitedImage is an image about to be decoded, checked against the limits
+type limitedImage struct {
+	r      io.Reader
+	ra     io.ReaderAt  // The image read at random, when the original reader allows it (for streaming TIFF)
+	config image.Config // Only known with limits
+	info   RenderInfo   // Format and bounds, only known with limits
+}
+
+// limit checks the image about to be decoded from r against settings.Limits. It returns the image to decode,
+// and the settings to render it with, sampled if it is oversized. The config is only decoded with limits.
+// The memory needed is left for the caller to check with Streaming, as streamed images aren't decoded at once.
+func (settings *RunGamutSettings) limit(r io.Reader) (*RunGamutSettings, limitedImage, error) {
+	img := limitedImage{r: r}
+	img.ra, _ = r.(io.ReaderAt)
+	if settings.Limits == (Limits{}) {
+		return settings, img, nil
+	}
+	var header bytes.Buffer
+	config, format, err := image.DecodeConfig(io.TeeReader(r, &header))
+	if err != nil {
+		return settings, img, fmt.Errorf("can't decode image: %w", err)
+	}
+	img.config = config
+	img.info = RenderInfo{Format: format, Bounds: image.Rect(0, 0, config.Width, config.Height)}
+	if !settings.Streaming {
+		if err := settings.Limits.checkMemory(config); err != nil {
+			return settings, img, err
+		}
+	}
+	sampling, err := settings.Limits.check(config, settings.Sampling)
+	if err != nil {
+		return settings, img, err
+	}
+	if sampling != settings.Sampling {
+		sampled := *settings
+		sampled.Sampling = sampling
+		settings = &sampled
+	}
+	img.r = io.MultiReader(&header, r)
+	return settings, img, nil
+}
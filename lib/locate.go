@@ -0,0 +1,107 @@
+package lib
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+)
+
+// locateDim is the portion of the brightness kept for the pixels outside of the region by Locate
+const locateDim = 0.2
+
+// Region is a part of the canvas of a gamut mask
+type Region interface {
+	// Contains tells if a color landed on the pixel p of the canvas belongs to the region.
+	// u and v are the coordinates of the color as returned by Projection.
+	Contains(p image.Point, u, v float64) bool
+}
+
+// Circle is a Region of the pixels which centers are within Radius from X, Y of the canvas
+type Circle struct {
+	X, Y, Radius float64
+}
+
+// Contains implements Region
+func (c Circle) Contains(p image.Point, u, v float64) bool {
+	dx, dy := float64(p.X)+0.5-c.X, float64(p.Y)+0.5-c.Y
+	return dx*dx+dy*dy <= c.Radius*c.Radius
+}
+
+// Polygon is a Region of the pixels which centers are inside of the polygon with the given vertices of the canvas
+type Polygon []image.Point
+
+// Contains implements Region
+func (polygon Polygon) Contains(p image.Point, u, v float64) bool {
+	// Even-odd rule against a ray going right from the pixel center
+	x, y := float64(p.X)+0.5, float64(p.Y)+0.5
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		xi, yi := float64(polygon[i].X), float64(polygon[i].Y)
+		xj, yj := float64(polygon[j].X), float64(polygon[j].Y)
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// HueSaturationRange is a Region of a ColorWheel between two hues (in degrees, going clockwise from MinHue
+// to MaxHue, so 330 to 30 covers reds) and two saturations (from 0 to 1, both inclusive)
+type HueSaturationRange struct {
+	MinHue, MaxHue               float64
+	MinSaturation, MaxSaturation float64
+}
+
+// Contains implements Region
+func (h HueSaturationRange) Contains(p image.Point, u, v float64) bool {
+	// Rounding off the error of going through the angle, so fully saturated colors stay within 1
+	saturation := math.Round(math.Hypot(u, v)*1e9) / 1e9
+	if saturation < h.MinSaturation || saturation > h.MaxSaturation {
+		return false
+	}
+	// Reverting the rotation of ColorWheel that puts Red on top
+	hue := math.Mod(math.Atan2(v, u)*180/math.Pi+90+360, 360)
+	minHue, maxHue := math.Mod(h.MinHue+360, 360), math.Mod(h.MaxHue+360, 360)
+	if minHue <= maxHue {
+		return hue >= minHue && hue <= maxHue
+	}
+	return hue >= minHue || hue <= maxHue
+}
+
+// Locate returns a copy of img where pixels that land in region of the gamut mask described by opts
+// are kept as they are while the others are dimmed. Pixels are projected exactly as GenerateGamutMask
+// does, so every highlighted pixel is one that has landed in region.
+//
+// Returns the amount of pixels that have landed in region as well.
+func Locate(img image.Image, region Region, opts Options) (located *image.RGBA64, matched int, err error) {
+	return LocateContext(context.Background(), img, region, opts)
+}
+
+// LocateContext is Locate that stops with ctx.Err() once ctx is done
+func LocateContext(ctx context.Context, img image.Image, region Region, opts Options) (located *image.RGBA64, matched int, err error) {
+	if err := opts.Validate(); err != nil {
+		return nil, 0, err
+	}
+	projection := opts.projection()
+	bounds := img.Bounds()
+	located = image.NewRGBA64(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		if (y-bounds.Min.Y)%tileRows == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, matched, err
+			}
+		}
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			p, u, v, _, ok := opts.place(projection, r, g, b)
+			if ok && region.Contains(p, u, v) {
+				matched++
+			} else {
+				r, g, b = uint32(float64(r)*locateDim), uint32(float64(g)*locateDim), uint32(float64(b)*locateDim)
+			}
+			located.SetRGBA64(x, y, color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)})
+		}
+	}
+	return located, matched, nil
+}
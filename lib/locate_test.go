@@ -0,0 +1,73 @@
+package lib_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/zzwx/gamutmask/lib"
+	"github.com/zzwx/gamutmask/lib/gamuttest"
+)
+
+// TestLocateExact checks that exactly the pixels landing on the spots of the region are kept,
+// the same spots their samples are collected into by GenerateGamutMask
+func TestLocateExact(t *testing.T) {
+	img := gamuttest.HSVSweep(90, 40, 0.8)
+	accumulator, err := lib.NewGamutAccumulatorWithOptions(lib.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accumulator.Add(img)
+	grid := accumulator.Grid()
+	for _, region := range []lib.Region{
+		lib.Circle{X: 160, Y: 100, Radius: 30},
+		lib.Polygon{{125, 125}, {240, 125}, {240, 240}},
+	} {
+		located, matched, err := lib.Locate(img, region, lib.DefaultOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		collected := 0
+		for y := 0; y < grid.Height; y++ {
+			for x := 0; x < grid.Width; x++ {
+				if region.Contains(image.Pt(x, y), 0, 0) {
+					collected += int(grid.At(x, y).Count)
+				}
+			}
+		}
+		if matched == 0 || matched != collected {
+			t.Errorf("%v: got %d pixels matched, want the %d collected in the region", region, matched, collected)
+		}
+
+		bounds := img.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				p, _ := lib.DefaultOptions.PointOf(img.At(x, y))
+				kept := color.RGBA64Model.Convert(located.At(x, y)) == color.RGBA64Model.Convert(img.At(x, y))
+				if region.Contains(p, 0, 0) != kept {
+					t.Errorf("%v: pixel %v landing at %v kept %v", region, image.Pt(x, y), p, kept)
+					return
+				}
+			}
+		}
+	}
+}
+
+func TestLocateHueSaturationRange(t *testing.T) {
+	img := gamuttest.HSVSweep(360, 2, 1) // A column of every hue in degrees, fully saturated on top
+	region := lib.HueSaturationRange{MinHue: 329.5, MaxHue: 30.5, MinSaturation: 0.9, MaxSaturation: 1}
+	located, matched, err := lib.Locate(img, region, lib.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 30 + 31; matched != want {
+		t.Errorf("got %d pixels matched, want %d", matched, want)
+	}
+	// Reds are kept, greens are dimmed
+	if got, want := color.RGBA64Model.Convert(located.At(0, 0)), color.RGBA64Model.Convert(img.At(0, 0)); got != want {
+		t.Errorf("red: got %v, want %v", got, want)
+	}
+	if got := located.RGBA64At(120, 0); got.G > 0x4000 {
+		t.Errorf("green: got %v, want it dimmed", got)
+	}
+}
@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"math"
+	"sync"
+)
+
+// HSVLookup is HSV using a precomputed table instead of converting every pixel.
+// Hue and saturation of a color with 8-bit components only depend on which component is the largest,
+// the difference between the largest and the smallest one and the difference between the other two,
+// so the table keeps the direction of every such hue (6MB) and is filled on first use.
+// The key (V of HSV) is calculated exactly as HSV does.
+//
+// Colors with 16-bit components (including the ones of JPEG images, which are converted from YCbCr
+// with extra precision) are looked up by the nearest 8-bit color, so they may land a pixel away from
+// where HSV would put them. Due to rounding, the same may rarely happen to 8-bit colors falling
+// right on the border of two pixels.
+var HSVLookup Projection = &hsvTable{}
+
+// hsvTable is the lookup table of HSVLookup
+type hsvTable struct {
+	once sync.Once
+	// Pairs of cos, sin of hues, indexed by hueIndex
+	directions []float64
+}
+
+// hueIndex is the index of the direction of the hue in hsvTable,
+// where sector is the largest component (0 for red, 1 for green, 2 for blue),
+// c is the difference between the largest and the smallest components (1 to 255)
+// and d is the difference between the components following the largest one (-c to c)
+func hueIndex(sector, c int, d int) int {
+	return ((sector*256+c)*511 + d + 255) * 2
+}
+
+// Project implements Projection
+func (t *hsvTable) Project(r, g, b uint32) (x, y, key float64) {
+	t.once.Do(t.fill)
+
+	// The key is V of HSV, calculated the same way as in hsv
+	v := r
+	if g > v {
+		v = g
+	}
+	if b > v {
+		v = b
+	}
+	key = float64(v) / float64(0xFFFF)
+
+	r8, g8, b8 := to8(r), to8(g), to8(b)
+	max, min := r8, r8
+	sector, d := 0, g8-b8
+	if g8 >= max {
+		max, sector, d = g8, 1, b8-r8
+	}
+	if b8 >= max {
+		max, sector, d = b8, 2, r8-g8
+	}
+	if g8 < min {
+		min = g8
+	}
+	if b8 < min {
+		min = b8
+	}
+	c := max - min
+	if c == 0 {
+		return 0, 0, key
+	}
+	s := float64(c) / float64(max)
+	i := hueIndex(sector, c, d)
+	return t.directions[i] * s, t.directions[i+1] * s, key
+}
+
+// to8 rounds a 16-bit component to the nearest 8-bit one
+func to8(c uint32) int {
+	return int((c*0xFF + 0x7FFF) / 0xFFFF)
+}
+
+func (t *hsvTable) fill() {
+	t.directions = make([]float64, hueIndex(2, 255, 255)+2)
+	for sector := 0; sector < 3; sector++ {
+		for c := 1; c < 256; c++ {
+			for d := -c; d <= c; d++ {
+				// Any color of the sector with such differences has the same hue
+				var rgb [3]int
+				rgb[sector] = c
+				if d >= 0 {
+					rgb[(sector+1)%3] = d
+				} else {
+					rgb[(sector+2)%3] = -d
+				}
+				h, _, _ := hsv(uint32(rgb[0]*0x101), uint32(rgb[1]*0x101), uint32(rgb[2]*0x101))
+				sin, cos := math.Sincos(h*math.Pi/180 - math.Pi/2)
+				i := hueIndex(sector, c, d)
+				t.directions[i], t.directions[i+1] = cos, sin
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package lib_test
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/zzwx/gamutmask/lib"
+	"github.com/zzwx/gamutmask/lib/gamuttest"
+)
+
+// TestHSVLookup checks 8-bit colors land where HSV puts them but for the rare ones falling right
+// on the border of two pixels, and 16-bit ones no further than a pixel away
+func TestHSVLookup(t *testing.T) {
+	hsv, lut := lib.DefaultOptions, lib.DefaultOptions
+	hsv.Projection, lut.Projection = lib.HSV, lib.HSVLookup
+	total, moved := 0, 0
+	for r := 0; r < 256; r += 3 {
+		for g := 0; g < 256; g += 5 {
+			for b := 0; b < 256; b += 7 {
+				for _, c := range []color.Color{
+					color.RGBA{uint8(r), uint8(g), uint8(b), 255},
+					color.RGBA64{uint16(r*0x101 + b), uint16(g*0x101 + r), uint16(b * 0x101), 0xFFFF},
+				} {
+					want, _ := hsv.PointOf(c)
+					got, _ := lut.PointOf(c)
+					if d := got.Sub(want); d.X < -1 || d.X > 1 || d.Y < -1 || d.Y > 1 {
+						t.Fatalf("%v lands at %v, want %v", c, got, want)
+					}
+					if _, is8 := c.(color.RGBA); is8 {
+						total++
+						if got != want {
+							moved++
+						}
+					}
+				}
+				cr, cg, cb, _ := color.RGBA{uint8(r), uint8(g), uint8(b), 255}.RGBA()
+				if _, _, want := lib.HSV.Project(cr, cg, cb); want != keyOf(lib.HSVLookup, cr, cg, cb) {
+					t.Fatalf("key of %v differs", []int{r, g, b})
+				}
+			}
+		}
+	}
+	if moved*1000 > total {
+		t.Errorf("%d of %d 8-bit colors land elsewhere", moved, total)
+	}
+}
+
+func keyOf(p lib.Projection, r, g, b uint32) float64 {
+	_, _, key := p.Project(r, g, b)
+	return key
+}
+
+// BenchmarkHSVLookup compares converting every pixel into HSV with looking it up in the table of HSVLookup
+func BenchmarkHSVLookup(b *testing.B) {
+	photo := gamuttest.Photo(800, 600)
+	lib.HSVLookup.Project(0, 0, 0) // Filling the table is not measured
+	for _, projection := range []struct {
+		name       string
+		projection lib.Projection
+	}{
+		{"hsv", lib.HSV},
+		{"hsv-lut", lib.HSVLookup},
+	} {
+		b.Run(projection.name, func(b *testing.B) {
+			opts := benchmarkOptions
+			opts.Projection = projection.projection
+			gamuttest.BenchmarkAdd(b, photo, opts)
+		})
+	}
+}
@@ -0,0 +1,498 @@
+package lib
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fogleman/gg"
+)
+
+// MunsellColor is a color in Munsell notation
+type MunsellColor struct {
+	// Hue is the position on the hue circle from 0 to 100: the reds go up to 10 (5 being 5R),
+	// the yellow-reds up to 20 (15 being 5YR) and so on through Y, GY, G, BG, B, PB, P and RP
+	Hue    float64
+	Value  float64 // From 0 (black) to 10 (white)
+	Chroma float64 // 0 for neutral colors
+}
+
+// munsellHues are the families of Munsell hues in the order of the hue circle
+var munsellHues = []string{"R", "YR", "Y", "GY", "G", "BG", "B", "PB", "P", "RP"}
+
+// munsellNeutral is the chroma below which a color is written as neutral
+const munsellNeutral = 0.5
+
+// String returns the Munsell notation of c like "5R 4/14", or "N 5/" for a neutral color
+func (c MunsellColor) String() string {
+	if c.Chroma < munsellNeutral {
+		return "N " + munsellNumber(c.Value) + "/"
+	}
+	return MunsellHueName(c.Hue) + " " + munsellNumber(c.Value) + "/" + munsellNumber(c.Chroma)
+}
+
+// MarshalText implements encoding.TextMarshaler, writing the Munsell notation
+func (c MunsellColor) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// munsellNumber formats a Munsell number with at most one decimal
+func munsellNumber(v float64) string {
+	return strconv.FormatFloat(math.Round(v*10)/10, 'f', -1, 64)
+}
+
+// MunsellHueName returns the name of a position on the hue circle (see MunsellColor.Hue) like "2.5YR"
+func MunsellHueName(hue float64) string {
+	hue = math.Round(hue*10) / 10
+	hue = math.Mod(hue, 100)
+	if hue <= 0 {
+		hue += 100
+	}
+	family := int(math.Ceil(hue/10)) - 1
+	return munsellNumber(hue-float64(family)*10) + munsellHues[family]
+}
+
+// ParseMunsellHue returns the position on the hue circle (see MunsellColor.Hue) of a hue like "2.5YR" or "10RP"
+func ParseMunsellHue(name string) (float64, error) {
+	i := strings.IndexFunc(name, func(r rune) bool { return r >= 'A' && r <= 'Z' })
+	if i <= 0 {
+		return 0, fmt.Errorf("invalid Munsell hue %q", name)
+	}
+	step, err := strconv.ParseFloat(name[:i], 64)
+	if err != nil || step <= 0 || step > 10 {
+		return 0, fmt.Errorf("invalid Munsell hue %q", name)
+	}
+	for family, letters := range munsellHues {
+		if letters == name[i:] {
+			return float64(family)*10 + step, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid Munsell hue %q: unknown hue family %q", name, name[i:])
+}
+
+// MunsellRenotation keeps the Munsell renotation data (Newhall, Nickerson and Judd, 1943): the chromaticities
+// under illuminant C of Munsell colors, colors being converted into Munsell notation by interpolating between them.
+//
+// The data published by the Munsell Color Science Laboratory of the Rochester Institute of Technology as real.dat
+// is bundled once generated into munsell_data.go (see DefaultMunsellRenotation). Other data can be loaded with
+// LoadMunsellRenotation.
+type MunsellRenotation struct {
+	planes []munsellPlane // Ordered by value
+
+	once sync.Once
+}
+
+// munsellPlane keeps the colors of the renotation data of a single value
+type munsellPlane struct {
+	value   float64
+	samples []munsellSample
+
+	// Hue and chroma interpolated over a grid covering the chromaticities of the samples
+	minX, minY, stepX, stepY float64
+	grid                     [][2]float64
+}
+
+// munsellSample is a color of the renotation data with hue and chroma
+// as the point chroma away from the neutral center in the direction of the hue
+type munsellSample struct {
+	x, y float64 // Chromaticity under illuminant C
+	a, b float64
+}
+
+// munsellGridSize is the amount of points of a side of the grid the hue and chroma of a plane are interpolated over
+const munsellGridSize = 128
+
+// illuminantC is the white point the renotation data is measured under
+var illuminantC = XY{0.31006, 0.31616}
+
+// ErrMissingRenotation is wrapped by an OptionsError for a MunsellWheel without renotation data
+var ErrMissingRenotation = errors.New("munsell renotation data is missing")
+
+// ReadMunsellRenotation reads the renotation data in the format of real.dat: a line per color with the hue,
+// the value, the chroma and the x, y and Y of the color, separated by spaces, like "2.5R 5 10 0.4174 0.3046 19.77".
+// A header line starting with "h" is skipped.
+func ReadMunsellRenotation(r io.Reader) (*MunsellRenotation, error) {
+	planes := map[float64][]munsellSample{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.EqualFold(fields[0], "h") {
+			continue
+		}
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("can't read munsell renotation data: line %d: expected 6 fields", line)
+		}
+		hue, err := ParseMunsellHue(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("can't read munsell renotation data: line %d: %w", line, err)
+		}
+		var numbers [5]float64
+		for i := range numbers {
+			if numbers[i], err = strconv.ParseFloat(fields[i+1], 64); err != nil {
+				return nil, fmt.Errorf("can't read munsell renotation data: line %d: %w", line, err)
+			}
+		}
+		value, chroma := numbers[0], numbers[1]
+		sin, cos := math.Sincos(hue / 100 * 2 * math.Pi)
+		planes[value] = append(planes[value], munsellSample{x: numbers[2], y: numbers[3], a: chroma * cos, b: chroma * sin})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("can't read munsell renotation data: %w", err)
+	}
+	if len(planes) == 0 {
+		return nil, errors.New("can't read munsell renotation data: no colors")
+	}
+
+	renotation := &MunsellRenotation{}
+	for value, samples := range planes {
+		// Neutral colors of every value are at the white point
+		samples = append(samples, munsellSample{x: illuminantC.X, y: illuminantC.Y})
+		renotation.planes = append(renotation.planes, munsellPlane{value: value, samples: samples})
+	}
+	sort.Slice(renotation.planes, func(i, j int) bool {
+		return renotation.planes[i].value < renotation.planes[j].value
+	})
+	return renotation, nil
+}
+
+// LoadMunsellRenotation reads the renotation data stored in fileName (see ReadMunsellRenotation)
+func LoadMunsellRenotation(fileName string) (*MunsellRenotation, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("can't open munsell renotation data: %w", err)
+	}
+	defer file.Close()
+	return ReadMunsellRenotation(file)
+}
+
+//go:generate go run munsell_gen.go real.dat
+
+// defaultMunsellRenotation is the renotation data bundled in munsell_data.go, nil if it hasn't been generated
+var defaultMunsellRenotation = func() *MunsellRenotation {
+	if munsellRenotationData == "" {
+		return nil
+	}
+	renotation, err := ReadMunsellRenotation(strings.NewReader(munsellRenotationData))
+	if err != nil {
+		panic(err) // Checked by munsell_gen.go
+	}
+	return renotation
+}()
+
+// DefaultMunsellRenotation returns the renotation data bundled with gamutmask, which the "munsell" projection
+// is registered with. It returns nil if munsell_data.go hasn't been generated out of real.dat (see munsell_gen.go).
+func DefaultMunsellRenotation() *MunsellRenotation {
+	return defaultMunsellRenotation
+}
+
+func init() {
+	if defaultMunsellRenotation != nil {
+		RegisterProjection("munsell", MunsellWheel{Renotation: defaultMunsellRenotation, MaxChroma: DefaultMunsellMaxChroma})
+	}
+}
+
+// Munsell converts an sRGB color with 16-bit components into Munsell notation. The value is exact (ASTM D1535),
+// hue and chroma are interpolated between the closest colors of the renotation data of the closest values.
+// Colors beyond the renotation data get about the chroma of the closest colors of the data.
+func (m *MunsellRenotation) Munsell(r, g, b uint32) MunsellColor {
+	m.once.Do(m.fillGrids)
+
+	linear := [3]float64{
+		srgbLinear(float64(r) / float64(0xFFFF)),
+		srgbLinear(float64(g) / float64(0xFFFF)),
+		srgbLinear(float64(b) / float64(0xFFFF)),
+	}
+	xyz := multiply(srgbToC, linear)
+	sum := xyz[0] + xyz[1] + xyz[2]
+	if sum <= 0 {
+		return MunsellColor{}
+	}
+	value := munsellValue(xyz[1] * 100)
+	x, y := xyz[0]/sum, xyz[1]/sum
+
+	// Mixing the closest planes below and above the value
+	i := sort.Search(len(m.planes), func(i int) bool { return m.planes[i].value >= value })
+	var a, bb float64
+	switch {
+	case i == 0:
+		a, bb = m.planes[0].at(x, y)
+	case i == len(m.planes):
+		a, bb = m.planes[i-1].at(x, y)
+	default:
+		below, above := &m.planes[i-1], &m.planes[i]
+		t := (value - below.value) / (above.value - below.value)
+		a0, b0 := below.at(x, y)
+		a1, b1 := above.at(x, y)
+		a, bb = a0+(a1-a0)*t, b0+(b1-b0)*t
+	}
+
+	hue := math.Atan2(bb, a) / (2 * math.Pi) * 100
+	if hue <= 0 {
+		hue += 100
+	}
+	return MunsellColor{Hue: hue, Value: value, Chroma: math.Hypot(a, bb)}
+}
+
+// srgbToC converts linear sRGB into XYZ adapted to illuminant C with the Bradford transform
+var srgbToC = func() [3][3]float64 {
+	bradford := [3][3]float64{
+		{0.8951, 0.2664, -0.1614},
+		{-0.7502, 1.7135, 0.0367},
+		{0.0389, -0.0685, 1.0296},
+	}
+	white := func(w XY) [3]float64 {
+		return multiply(bradford, [3]float64{w.X / w.Y, 1, (1 - w.X - w.Y) / w.Y})
+	}
+	from, to := white(SRGB.White), white(illuminantC)
+	var scale [3][3]float64
+	for i := range scale {
+		scale[i][i] = to[i] / from[i]
+	}
+	return multiplyMatrices(invert(bradford), multiplyMatrices(scale, multiplyMatrices(bradford, SRGB.toXYZ())))
+}()
+
+func multiplyMatrices(m, n [3][3]float64) (r [3][3]float64) {
+	for i := range r {
+		for j := range r[i] {
+			r[i][j] = m[i][0]*n[0][j] + m[i][1]*n[1][j] + m[i][2]*n[2][j]
+		}
+	}
+	return r
+}
+
+// munsellValue returns the Munsell value of luminance Y from 0 to 100 by inverting the polynomial of ASTM D1535
+func munsellValue(y float64) float64 {
+	luminance := func(v float64) float64 {
+		return v * (1.1914 + v*(-0.22533+v*(0.23352+v*(-0.020484+v*0.00081939))))
+	}
+	low, high := 0.0, 10.0
+	for i := 0; i < 32; i++ {
+		if mid := (low + high) / 2; luminance(mid) < y {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return (low + high) / 2
+}
+
+func (m *MunsellRenotation) fillGrids() {
+	var wg sync.WaitGroup
+	for i := range m.planes {
+		wg.Add(1)
+		go func(p *munsellPlane) {
+			defer wg.Done()
+			p.fillGrid()
+		}(&m.planes[i])
+	}
+	wg.Wait()
+}
+
+// fillGrid interpolates hue and chroma over the grid covering the samples of the plane
+func (p *munsellPlane) fillGrid() {
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, s := range p.samples {
+		minX, minY = math.Min(minX, s.x), math.Min(minY, s.y)
+		maxX, maxY = math.Max(maxX, s.x), math.Max(maxY, s.y)
+	}
+	p.minX, p.minY = minX, minY
+	p.stepX = math.Max(maxX-minX, 1e-6) / (munsellGridSize - 1)
+	p.stepY = math.Max(maxY-minY, 1e-6) / (munsellGridSize - 1)
+	p.grid = make([][2]float64, munsellGridSize*munsellGridSize)
+	for j := 0; j < munsellGridSize; j++ {
+		for i := 0; i < munsellGridSize; i++ {
+			a, b := p.interpolate(minX+float64(i)*p.stepX, minY+float64(j)*p.stepY)
+			p.grid[j*munsellGridSize+i] = [2]float64{a, b}
+		}
+	}
+}
+
+// interpolate weighs the hue and chroma of the 4 samples closest to x, y by their inverse squared distance
+func (p *munsellPlane) interpolate(x, y float64) (a, b float64) {
+	const closest = 4
+	var nearest [closest]struct {
+		distance float64
+		sample   *munsellSample
+	}
+	for i := range nearest {
+		nearest[i].distance = math.Inf(1)
+	}
+	for i := range p.samples {
+		s := &p.samples[i]
+		d := (s.x-x)*(s.x-x) + (s.y-y)*(s.y-y)
+		if d < 1e-12 {
+			return s.a, s.b
+		}
+		for k := range nearest {
+			if d < nearest[k].distance {
+				copy(nearest[k+1:], nearest[k:closest-1])
+				nearest[k].distance, nearest[k].sample = d, s
+				break
+			}
+		}
+	}
+	var weights float64
+	for _, n := range nearest {
+		if n.sample == nil {
+			continue
+		}
+		w := 1 / n.distance
+		a += n.sample.a * w
+		b += n.sample.b * w
+		weights += w
+	}
+	return a / weights, b / weights
+}
+
+// at returns the hue and chroma at x, y bilinearly interpolated over the grid
+func (p *munsellPlane) at(x, y float64) (a, b float64) {
+	clamp := func(v float64) float64 {
+		return math.Max(0, math.Min(munsellGridSize-1.000001, v))
+	}
+	u, v := clamp((x-p.minX)/p.stepX), clamp((y-p.minY)/p.stepY)
+	i, j := int(u), int(v)
+	u, v = u-float64(i), v-float64(j)
+	c00, c10 := p.grid[j*munsellGridSize+i], p.grid[j*munsellGridSize+i+1]
+	c01, c11 := p.grid[(j+1)*munsellGridSize+i], p.grid[(j+1)*munsellGridSize+i+1]
+	for k, c := range [2]*float64{&a, &b} {
+		top := c00[k] + (c10[k]-c00[k])*u
+		bottom := c01[k] + (c11[k]-c01[k])*u
+		*c = top + (bottom-top)*v
+	}
+	return a, b
+}
+
+// DefaultMunsellMaxChroma is the default MaxChroma of MunsellWheel, about the chroma of the most saturated sRGB colors
+const DefaultMunsellMaxChroma = 20
+
+// MunsellWheel is a Projection placing colors on the Munsell hue circle: the hue as an angle (with 5R on top
+// going clockwise through Y, G, B and P) and the chroma as the distance from the center, up to MaxChroma.
+// Higher Munsell value wins. The 10 principal hues are labeled around the wheel.
+type MunsellWheel struct {
+	Renotation *MunsellRenotation
+	// MaxChroma is the chroma landing on the edge of the wheel. Colors with a higher chroma are drawn on the edge.
+	MaxChroma float64
+}
+
+// Project implements Projection
+func (w MunsellWheel) Project(r, g, b uint32) (x, y, key float64) {
+	c := w.Renotation.Munsell(r, g, b)
+	distance := math.Min(c.Chroma/w.MaxChroma, 1)
+	sin, cos := math.Sincos(w.angle(c.Hue))
+	return cos * distance, sin * distance, c.Value / 10
+}
+
+// Validate implements Validator
+func (w MunsellWheel) Validate() error {
+	if !(w.MaxChroma > 0) {
+		return &OptionsError{Field: "MaxChroma", Value: w.MaxChroma, Err: ErrInvalidMaxChroma}
+	}
+	if w.Renotation == nil {
+		return ErrMissingRenotation
+	}
+	return nil
+}
+
+// angle returns the angle of a hue on the canvas, with 5R on top
+func (w MunsellWheel) angle(hue float64) float64 {
+	return (hue-5)/100*2*math.Pi - math.Pi/2
+}
+
+// DrawOverlay implements Overlay, labeling the 10 principal hues with the default font of gg
+func (w MunsellWheel) DrawOverlay(context *gg.Context, opts Options) {
+	context.SetRGB(0.75, 0.75, 0.75)
+	for family, letters := range munsellHues {
+		sin, cos := math.Sincos(w.angle(float64(family)*10 + 5))
+		x, y := opts.CanvasPoint(cos*0.88, sin*0.88)
+		context.DrawStringAnchored("5"+letters, x, y, 0.5, 0.35)
+	}
+}
+
+// MunsellSummary describes the Munsell hue, value and chroma most of the samples of an image have
+type MunsellSummary struct {
+	// Dominant is the hue (by 2.5) with the most samples along with the value and the chroma (by 2) most of
+	// the samples of that hue have. It is a neutral color if most of the samples are neutral.
+	Dominant MunsellColor `json:"dominant"`
+	Share    float64      `json:"share"`   // Portion of the samples having the dominant hue
+	Neutral  float64      `json:"neutral"` // Portion of the samples with a chroma below 1
+	Samples  uint64       `json:"samples"`
+}
+
+// Summarize converts every color counted (see GamutAccumulator.CountColors) into Munsell notation
+// and finds the dominant hue, value and chroma
+func (m *MunsellRenotation) Summarize(colors *ColorCounts) MunsellSummary {
+	type sample struct {
+		color MunsellColor
+		count uint64
+	}
+	var samples []sample
+	var summary MunsellSummary
+	var hues [40]uint64
+	var neutral uint64
+	colors.Each(func(r, g, b uint16, n uint64) {
+		c := m.Munsell(uint32(r), uint32(g), uint32(b))
+		samples = append(samples, sample{c, n})
+		summary.Samples += n
+		if c.Chroma < 1 {
+			neutral += n
+			return
+		}
+		hues[munsellPage(c.Hue)] += n
+	})
+	if summary.Samples == 0 {
+		return summary
+	}
+
+	dominant := 0
+	for page, count := range hues {
+		if count > hues[dominant] {
+			dominant = page
+		}
+	}
+	isNeutral := neutral >= hues[dominant]
+	var values [11]uint64
+	chromas := map[int]uint64{}
+	for _, s := range samples {
+		if isNeutral != (s.color.Chroma < 1) || (!isNeutral && munsellPage(s.color.Hue) != dominant) {
+			continue
+		}
+		values[int(math.Round(s.color.Value))] += s.count
+		chromas[int(math.Round(s.color.Chroma/2))*2] += s.count
+	}
+	value := 0
+	for v, count := range values {
+		if count > values[value] {
+			value = v
+		}
+	}
+	summary.Dominant.Value = float64(value)
+	summary.Neutral = float64(neutral) / float64(summary.Samples)
+	if isNeutral {
+		summary.Share = summary.Neutral
+		return summary
+	}
+	chroma := 0
+	for c, count := range chromas {
+		if count > chromas[chroma] || (count == chromas[chroma] && c < chroma) {
+			chroma = c
+		}
+	}
+	summary.Dominant.Hue = float64(dominant+1) * 2.5
+	summary.Dominant.Chroma = math.Max(float64(chroma), 2)
+	summary.Share = float64(hues[dominant]) / float64(summary.Samples)
+	return summary
+}
+
+// munsellPage returns the index of the closest of the 40 hues by 2.5 (2.5R being 0)
+func munsellPage(hue float64) int {
+	return (int(math.Round(hue/2.5)) + 39) % 40
+}
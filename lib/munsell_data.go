@@ -0,0 +1,6 @@
+package lib
+
+// munsellRenotationData is the content of the real.dat file of the Munsell renotation data, written by
+// munsell_gen.go (see go generate in munsell.go). It stays empty until the file is generated, in which case
+// DefaultMunsellRenotation returns nil and the renotation data has to be loaded with LoadMunsellRenotation.
+const munsellRenotationData = ""
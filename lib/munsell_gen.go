@@ -0,0 +1,58 @@
+//go:build ignore
+// +build ignore
+
+// munsell_gen.go writes munsell_data.go out of the real.dat file of the Munsell renotation data
+// published by the Munsell Color Science Laboratory of the Rochester Institute of Technology:
+//
+//	go run munsell_gen.go path/to/real.dat
+//
+// It is run by go generate with real.dat placed next to it.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/zzwx/gamutmask/lib"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: go run munsell_gen.go real.dat")
+		os.Exit(2)
+	}
+	if err := generate(os.Args[1], "munsell_data.go"); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func generate(inputFileName, outputFileName string) error {
+	data, err := ioutil.ReadFile(inputFileName)
+	if err != nil {
+		return err
+	}
+	if _, err := lib.ReadMunsellRenotation(bytes.NewReader(data)); err != nil {
+		return err
+	}
+	// Keeping the lines as they are, without the carriage returns and trailing spaces
+	lines := strings.Split(strings.TrimSpace(strings.Replace(string(data), "\r", "", -1)), "\n")
+	for i := range lines {
+		lines[i] = strings.Join(strings.Fields(lines[i]), " ")
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by munsell_gen.go from %s; DO NOT EDIT.\n\n", inputFileName)
+	fmt.Fprintf(&out, "package lib\n\n")
+	fmt.Fprintf(&out, "// munsellRenotationData is the content of the real.dat file of the Munsell renotation data\n")
+	fmt.Fprintf(&out, "const munsellRenotationData = `%s\n`\n", strings.Join(lines, "\n"))
+	source, err := format.Source(out.Bytes())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(outputFileName, source, 0644)
+}
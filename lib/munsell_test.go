@@ -0,0 +1,187 @@
+package lib_test
+
+import (
+	"image"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zzwx/gamutmask/lib"
+	"github.com/zzwx/gamutmask/lib/gamuttest"
+)
+
+// testRenotation is a handful of made-up colors in the format of real.dat, only good enough
+// for checking what doesn't depend on the actual renotation data
+const testRenotation = `h V C x y Y
+5R 4 8 0.45 0.31 12
+5Y 4 8 0.42 0.45 12
+5G 4 8 0.24 0.40 12
+5B 4 8 0.22 0.25 12
+5P 4 8 0.30 0.22 12
+5R 7 8 0.40 0.32 43
+5Y 7 8 0.40 0.43 43
+5G 7 8 0.26 0.38 43
+5B 7 8 0.24 0.27 43
+5P 7 8 0.31 0.25 43
+`
+
+func TestParseMunsellHue(t *testing.T) {
+	for _, name := range []string{"2.5R", "5YR", "10Y", "7.5PB", "10RP"} {
+		hue, err := lib.ParseMunsellHue(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := lib.MunsellHueName(hue); got != name {
+			t.Errorf("got %q, want %q", got, name)
+		}
+	}
+	for _, name := range []string{"R", "0R", "11Y", "5Q"} {
+		if _, err := lib.ParseMunsellHue(name); err == nil {
+			t.Errorf("%q parsed", name)
+		}
+	}
+}
+
+func TestReadMunsellRenotation(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"h V C x y Y\n",
+		"5R 4 8 0.45 0.31\n",
+		"5Q 4 8 0.45 0.31 12\n",
+		"5R 4 eight 0.45 0.31 12\n",
+	} {
+		if _, err := lib.ReadMunsellRenotation(strings.NewReader(data)); err == nil {
+			t.Errorf("%q read", data)
+		}
+	}
+
+	renotation, err := lib.ReadMunsellRenotation(strings.NewReader(testRenotation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Values don't depend on the renotation data and grays are neutral
+	if c := renotation.Munsell(0xFFFF, 0xFFFF, 0xFFFF); math.Abs(c.Value-10) > 0.01 || c.Chroma > 0.1 {
+		t.Errorf("got %v for white, want N 10/", c)
+	}
+	if c := renotation.Munsell(0x7777, 0x7777, 0x7777); c.Chroma > 0.1 {
+		t.Errorf("got %v for a gray, want a neutral color", c)
+	}
+}
+
+// TestMunsellRenotationSamples converts the colors of the actual renotation data that are within sRGB, like 5R 4/14,
+// back into their notation. It runs with the bundled data, or with the real.dat file set in GAMUTTEST_MUNSELL.
+func TestMunsellRenotationSamples(t *testing.T) {
+	data := lib.MunsellRenotationData
+	if fileName := os.Getenv("GAMUTTEST_MUNSELL"); fileName != "" {
+		b, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = string(b)
+	}
+	if data == "" {
+		t.Skip("munsell_data.go hasn't been generated, set GAMUTTEST_MUNSELL to the path of real.dat")
+	}
+	renotation, err := lib.ReadMunsellRenotation(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	toSRGB := lib.Invert(lib.SRGBToC())
+	encode := func(v float64) uint32 {
+		if v > 0.0031308 {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		} else {
+			v *= 12.92
+		}
+		return uint32(math.Round(v * 0xFFFF))
+	}
+
+	checked := 0
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 6 || strings.EqualFold(fields[0], "h") {
+			continue
+		}
+		hue, err := lib.ParseMunsellHue(fields[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		var numbers [5]float64
+		for i := range numbers {
+			if numbers[i], err = strconv.ParseFloat(fields[i+1], 64); err != nil {
+				t.Fatal(err)
+			}
+		}
+		value, chroma, x, y, luminance := numbers[0], numbers[1], numbers[2], numbers[3], numbers[4]/100
+		if chroma < 4 {
+			continue // Hue is hardly defined close to the neutral colors
+		}
+		xyz := [3]float64{x / y * luminance, luminance, (1 - x - y) / y * luminance}
+		var rgb [3]uint32
+		inside := true
+		for i, row := range toSRGB {
+			linear := row[0]*xyz[0] + row[1]*xyz[1] + row[2]*xyz[2]
+			if linear < 0 || linear > 1 {
+				inside = false
+				break
+			}
+			rgb[i] = encode(linear)
+		}
+		if !inside {
+			continue
+		}
+		checked++
+
+		c := renotation.Munsell(rgb[0], rgb[1], rgb[2])
+		hueDistance := math.Abs(c.Hue - hue)
+		hueDistance = math.Min(hueDistance, 100-hueDistance)
+		if math.Abs(c.Value-value) > 0.1 || hueDistance > 2.5 || math.Abs(c.Chroma-chroma) > math.Max(1, chroma/10) {
+			t.Errorf("got %v for %s %v/%v", c, fields[0], value, chroma)
+		}
+	}
+	if checked == 0 {
+		t.Error("no colors of the renotation data within sRGB")
+	}
+	t.Logf("%d colors checked", checked)
+}
+
+// TestSummarize checks the summary is computed out of the colors of the pixels,
+// regardless of the size and the projection of the wheel
+func TestSummarize(t *testing.T) {
+	renotation, err := lib.ReadMunsellRenotation(strings.NewReader(testRenotation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := gamuttest.Photo(300, 200)
+	var want lib.MunsellSummary
+	for i, opts := range []lib.Options{
+		lib.DefaultOptions,
+		{Width: 40, Height: 40},
+		{Width: 500, Height: 300, PaddingX: 10, PaddingY: 10, Projection: lib.CIELAB},
+	} {
+		accumulator := accumulate(t, opts)
+		accumulator.CountColors()
+		accumulator.Add(img)
+		summary := renotation.Summarize(accumulator.Colors())
+		if summary.Samples != 300*200 {
+			t.Errorf("got %d samples, want %d", summary.Samples, 300*200)
+		}
+		if i == 0 {
+			want = summary
+		} else if !reflect.DeepEqual(summary, want) {
+			t.Errorf("%dx%d: got %+v, want %+v", opts.Width, opts.Height, summary, want)
+		}
+	}
+
+	// A single color is all of the samples
+	accumulator := accumulate(t, lib.DefaultOptions)
+	accumulator.CountColors()
+	accumulator.Add(gamuttest.Solid(image.White, 10, 10))
+	if summary := renotation.Summarize(accumulator.Colors()); summary.Share != 1 || summary.Neutral != 1 || summary.Dominant.Value != 10 {
+		t.Errorf("got %+v, want all of the samples N 10/", summary)
+	}
+}
@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"runtime"
+)
+
+// Options describe how a gamut mask is generated. New settings are added here so
+// GenerateGamutMaskWithOptions keeps its signature. Zero values of the settings added
+// after the size and padding always mean the behavior of GenerateGamutMask.
+type Options struct {
+	Width    int // Width of the resulting wheel
+	Height   int // Height of the resulting wheel
+	PaddingX int // Horizontal distance between the edges of the image and the most saturated colors
+	PaddingY int // Vertical distance between the edges of the image and the most saturated colors
+
+	Projection Projection // How colors are placed on the canvas. HSV when nil.
+	Renderer   Renderer   // How the collected colors are drawn. BrightestRenderer when nil.
+
+	// MarkerRadius is the radius of markers drawn for lists of colors by GenerateGamutMaskFromColors.
+	// A 50th of the smaller side of the canvas (but at least 2 pixels) when zero.
+	MarkerRadius float64
+
+	// Workers is the amount of goroutines projecting pixels concurrently, runtime.NumCPU() when zero.
+	// Every worker keeps a Grid of its own.
+	Workers int
+
+	// Sampling picks the pixels of images to project, every pixel by default
+	Sampling Sampling
+}
+
+// DefaultOptions are the options the command-line utility uses by default
+var DefaultOptions = Options{
+	Width:    250,
+	Height:   250,
+	PaddingX: 2,
+	PaddingY: 2,
+}
+
+var (
+	// ErrInvalidSize is wrapped by an OptionsError when the size of the mask is not positive
+	ErrInvalidSize = errors.New("size must be positive")
+	// ErrInvalidPadding is wrapped by an OptionsError when the padding is negative
+	// or leaves no room for the wheel
+	ErrInvalidPadding = errors.New("padding must be non-negative and less than half of the size")
+	// ErrInvalidMarkerRadius is wrapped by an OptionsError when the marker radius is negative
+	ErrInvalidMarkerRadius = errors.New("marker radius must be non-negative")
+	// ErrInvalidWorkers is wrapped by an OptionsError when the amount of workers is negative
+	ErrInvalidWorkers = errors.New("workers must be non-negative")
+)
+
+// OptionsError describes an invalid field of Options. Use errors.Is against
+// the Err... variables of this package to find out the reason.
+type OptionsError struct {
+	Field string
+	Value interface{}
+	Err   error
+}
+
+func (e *OptionsError) Error() string {
+	return fmt.Sprintf("invalid %s %v: %v", e.Field, e.Value, e.Err)
+}
+
+func (e *OptionsError) Unwrap() error {
+	return e.Err
+}
+
+// Validate returns an *OptionsError for the first invalid field found, or nil
+func (o Options) Validate() error {
+	if o.Width <= 0 {
+		return &OptionsError{Field: "Width", Value: o.Width, Err: ErrInvalidSize}
+	}
+	if o.Height <= 0 {
+		return &OptionsError{Field: "Height", Value: o.Height, Err: ErrInvalidSize}
+	}
+	if o.PaddingX < 0 || o.PaddingX*2 >= o.Width {
+		return &OptionsError{Field: "PaddingX", Value: o.PaddingX, Err: ErrInvalidPadding}
+	}
+	if o.PaddingY < 0 || o.PaddingY*2 >= o.Height {
+		return &OptionsError{Field: "PaddingY", Value: o.PaddingY, Err: ErrInvalidPadding}
+	}
+	if o.MarkerRadius < 0 {
+		return &OptionsError{Field: "MarkerRadius", Value: o.MarkerRadius, Err: ErrInvalidMarkerRadius}
+	}
+	if o.Workers < 0 {
+		return &OptionsError{Field: "Workers", Value: o.Workers, Err: ErrInvalidWorkers}
+	}
+	if v, ok := o.Projection.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			var optionsErr *OptionsError
+			if errors.As(err, &optionsErr) {
+				return err
+			}
+			return &OptionsError{Field: "Projection", Value: o.Projection, Err: err}
+		}
+	}
+	if err := o.Sampling.validate(); err != nil {
+		return &OptionsError{Field: "Sampling", Value: o.Sampling, Err: err}
+	}
+	return nil
+}
+
+// sameGeometry tells if o and other place colors on the same spots of the same canvas
+func (o Options) sameGeometry(other Options) bool {
+	return o.Width == other.Width && o.Height == other.Height &&
+		o.PaddingX == other.PaddingX && o.PaddingY == other.PaddingY
+}
+
+// projection returns the Projection to use, HSV by default
+func (o Options) projection() Projection {
+	if o.Projection == nil {
+		return HSV
+	}
+	return o.Projection
+}
+
+// renderer returns the Renderer to use, BrightestRenderer by default
+func (o Options) renderer() Renderer {
+	if o.Renderer == nil {
+		return BrightestRenderer{}
+	}
+	return o.Renderer
+}
+
+// PointOf returns the pixel of the canvas c lands on, or false if it lands outside of the canvas
+func (o Options) PointOf(c color.Color) (p image.Point, ok bool) {
+	r, g, b, _ := c.RGBA()
+	p, _, _, _, ok = o.place(o.projection(), r, g, b)
+	return p, ok
+}
+
+// place projects the color and returns the pixel of the canvas it lands on along with the
+// coordinates and the key returned by projection. ok is false if the pixel is outside of the canvas.
+func (o Options) place(projection Projection, r, g, b uint32) (p image.Point, u, v, key float64, ok bool) {
+	u, v, key = projection.Project(r, g, b)
+	x, y := o.CanvasPoint(u, v)
+
+	p = image.Point{int(x), int(y)}
+	ok = p.X >= 0 && p.X < o.Width && p.Y >= 0 && p.Y < o.Height
+	return p, u, v, key, ok
+}
+
+// CanvasPoint returns where the point u, v of the square from -1 to 1 returned by projections lands on the canvas
+func (o Options) CanvasPoint(u, v float64) (x, y float64) {
+	x = u*float64(o.Width-o.PaddingX*2)/2.0 + float64(o.Width)/2.0
+	y = v*float64(o.Height-o.PaddingY*2)/2.0 + float64(o.Height)/2.0
+	return x, y
+}
+
+// markerRadius returns the radius of markers to use
+func (o Options) markerRadius() float64 {
+	if o.MarkerRadius == 0 {
+		return math.Max(2, math.Min(float64(o.Width), float64(o.Height))/50)
+	}
+	return o.MarkerRadius
+}
+
+// workers returns the amount of goroutines to project pixels with
+func (o Options) workers() int {
+	if o.Workers == 0 {
+		return runtime.NumCPU()
+	}
+	return o.Workers
+}
@@ -0,0 +1,73 @@
+package lib_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zzwx/gamutmask/lib"
+	"github.com/zzwx/gamutmask/lib/gamuttest"
+)
+
+// invalidProjection is a projection which settings never validate
+type invalidProjection struct{}
+
+var errInvalidProjection = errors.New("invalid projection")
+
+func (invalidProjection) Project(r, g, b uint32) (x, y, key float64) {
+	return 0, 0, 0
+}
+
+func (invalidProjection) Validate() error {
+	return errInvalidProjection
+}
+
+func TestOptionsValidate(t *testing.T) {
+	valid := lib.DefaultOptions
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("default options: %v", err)
+	}
+	for _, test := range []struct {
+		change func(o *lib.Options)
+		field  string
+		want   error
+	}{
+		{func(o *lib.Options) { o.Width = 0 }, "Width", lib.ErrInvalidSize},
+		{func(o *lib.Options) { o.Height = -1 }, "Height", lib.ErrInvalidSize},
+		{func(o *lib.Options) { o.PaddingX = -1 }, "PaddingX", lib.ErrInvalidPadding},
+		{func(o *lib.Options) { o.PaddingY = 125 }, "PaddingY", lib.ErrInvalidPadding},
+		{func(o *lib.Options) { o.MarkerRadius = -1 }, "MarkerRadius", lib.ErrInvalidMarkerRadius},
+		{func(o *lib.Options) { o.Workers = -1 }, "Workers", lib.ErrInvalidWorkers},
+		{func(o *lib.Options) { o.Sampling = lib.Sampling{Rate: 2} }, "Sampling", lib.ErrInvalidSampling},
+		{func(o *lib.Options) { o.Projection = lib.ChromaPlane{MaxChroma: 0} }, "MaxChroma", lib.ErrInvalidMaxChroma},
+		{func(o *lib.Options) { o.Projection = lib.MunsellWheel{MaxChroma: -1} }, "MaxChroma", lib.ErrInvalidMaxChroma},
+		{func(o *lib.Options) { o.Projection = lib.MunsellWheel{MaxChroma: 20} }, "Projection", lib.ErrMissingRenotation},
+		{func(o *lib.Options) { o.Projection = invalidProjection{} }, "Projection", errInvalidProjection},
+	} {
+		opts := valid
+		test.change(&opts)
+		err := opts.Validate()
+		if !errors.Is(err, test.want) {
+			t.Errorf("got %v, want %v", err, test.want)
+			continue
+		}
+		var optionsErr *lib.OptionsError
+		if !errors.As(err, &optionsErr) || optionsErr.Field != test.field {
+			t.Errorf("got %v, want an *OptionsError of %s", err, test.field)
+		}
+		if _, err := lib.NewGamutAccumulatorWithOptions(opts); !errors.Is(err, test.want) {
+			t.Errorf("NewGamutAccumulatorWithOptions: got %v, want %v", err, test.want)
+		}
+		if _, err := lib.GenerateGamutMaskWithOptions(gamuttest.HSVSweep(10, 10, 1), opts); !errors.Is(err, test.want) {
+			t.Errorf("GenerateGamutMaskWithOptions: got %v, want %v", err, test.want)
+		}
+	}
+}
+
+func TestGenerateGamutMaskWithOptions(t *testing.T) {
+	img := gamuttest.HSVSweep(120, 60, 0.8)
+	wheel, err := lib.GenerateGamutMaskWithOptions(img, lib.Options{Width: 300, Height: 200, PaddingX: 10, PaddingY: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSameWheels(t, lib.GenerateGamutMask(img, 300, 200, 10, 4), wheel)
+}
@@ -0,0 +1,97 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+
+	"github.com/fogleman/gg"
+)
+
+// ErrInvalidWeights is returned when the weights don't match the colors or any of them is negative
+var ErrInvalidWeights = errors.New("weights must be non-negative and one per color")
+
+// GenerateGamutMaskFromColors generates a wheel (as *image.RGBA64) of Gamut Mask of a list of colors.
+// Colors go through the same projection and renderer as the pixels of GenerateGamutMaskWithOptions
+// and every one of them is drawn on top as a marker of Options.MarkerRadius.
+//
+// weights are optional (nil for all colors being equal), the area of every marker is proportional
+// to its weight with the heaviest color getting the full radius.
+func GenerateGamutMaskFromColors(colors []color.Color, weights []float64, opts Options) (wheel *image.RGBA64, err error) {
+	if weights != nil && len(weights) != len(colors) {
+		return nil, fmt.Errorf("%w: %d weights for %d colors", ErrInvalidWeights, len(weights), len(colors))
+	}
+	maxWeight := 0.0
+	for _, weight := range weights {
+		if weight < 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidWeights, weight)
+		}
+		maxWeight = math.Max(maxWeight, weight)
+	}
+
+	accumulator, err := NewGamutAccumulatorWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	type marker struct {
+		point  image.Point
+		color  color.Color
+		radius float64
+	}
+	markers := make([]marker, 0, len(colors))
+	for i, c := range colors {
+		accumulator.AddColor(c)
+		point, ok := opts.PointOf(c)
+		if !ok {
+			continue
+		}
+		radius := opts.markerRadius()
+		if weights != nil {
+			if maxWeight == 0 {
+				continue
+			}
+			radius *= math.Sqrt(weights[i] / maxWeight)
+		}
+		markers = append(markers, marker{point, c, math.Max(radius, 1)})
+	}
+	wheel = accumulator.Render()
+
+	// Drawing the biggest markers first so the smaller ones stay visible
+	sort.SliceStable(markers, func(i, j int) bool {
+		return markers[i].radius > markers[j].radius
+	})
+	drawOver(wheel, func(context *gg.Context) {
+		context.SetLineWidth(1)
+		for _, m := range markers {
+			context.DrawCircle(float64(m.point.X)+0.5, float64(m.point.Y)+0.5, m.radius)
+			context.SetColor(m.color)
+			context.FillPreserve()
+			context.SetRGB(0.5, 0.5, 0.5)
+			context.Stroke()
+		}
+	})
+	return wheel, nil
+}
+
+// GenerateGamutMaskFromPalette is GenerateGamutMaskFromColors for the colors of palette having the same weight
+func GenerateGamutMaskFromPalette(palette color.Palette, opts Options) (wheel *image.RGBA64, err error) {
+	return GenerateGamutMaskFromColors(palette, nil, opts)
+}
+
+// AddColor projects a single color onto the wheel as if it was a pixel of an image
+func (a *GamutAccumulator) AddColor(c color.Color) {
+	r, g, b, _ := c.RGBA()
+	a.add(r, g, b)
+}
+
+// drawOver draws onto canvas whatever drawFunc draws onto a transparent context of the same size
+func drawOver(canvas *image.RGBA64, drawFunc func(context *gg.Context)) {
+	bounds := canvas.Bounds()
+	context := gg.NewContext(bounds.Dx(), bounds.Dy())
+	drawFunc(context)
+	draw.Draw(canvas, bounds, context.Image(), image.Point{}, draw.Over)
+}
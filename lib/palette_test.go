@@ -0,0 +1,58 @@
+package lib_test
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/zzwx/gamutmask/lib"
+)
+
+// expectMarker fails t unless c is drawn as a marker covering the pixels within radius of where c lands
+func expectMarker(t *testing.T, wheel image.Image, c color.Color, radius int) {
+	t.Helper()
+	p, ok := lib.DefaultOptions.PointOf(c)
+	if !ok {
+		t.Fatalf("%v lands outside of the canvas", c)
+	}
+	want := color.RGBA64Model.Convert(c)
+	for _, d := range []image.Point{{0, 0}, {radius, 0}, {-radius, 0}, {0, radius}, {0, -radius}} {
+		if got := color.RGBA64Model.Convert(wheel.At(p.X+d.X, p.Y+d.Y)); got != want {
+			t.Errorf("%v: got %v at %v, want a marker", c, got, p.Add(d))
+		}
+	}
+}
+
+func TestGenerateGamutMaskFromColors(t *testing.T) {
+	// Dark enough to land well inside of the wheel
+	red, blue := color.RGBA{200, 60, 60, 255}, color.RGBA{60, 60, 200, 255}
+	wheel, err := lib.GenerateGamutMaskFromPalette(color.Palette{red, blue}, lib.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The default radius of markers of a 250x250 wheel is 5
+	expectMarker(t, wheel, red, 3)
+	expectMarker(t, wheel, blue, 3)
+
+	// The area of a marker is proportional to its weight
+	wheel, err = lib.GenerateGamutMaskFromColors([]color.Color{red, blue}, []float64{4, 1}, lib.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMarker(t, wheel, red, 3)
+	expectMarker(t, wheel, blue, 1)
+	p, _ := lib.DefaultOptions.PointOf(blue)
+	if got := color.RGBA64Model.Convert(wheel.At(p.X+3, p.Y)); got == color.RGBA64Model.Convert(blue) {
+		t.Errorf("marker of a quarter of the weight drawn with the full radius")
+	}
+}
+
+func TestGenerateGamutMaskFromColorsInvalidWeights(t *testing.T) {
+	colors := []color.Color{color.White, color.Black}
+	for _, weights := range [][]float64{{1}, {1, -1}} {
+		if _, err := lib.GenerateGamutMaskFromColors(colors, weights, lib.DefaultOptions); !errors.Is(err, lib.ErrInvalidWeights) {
+			t.Errorf("%v: got %v, want %v", weights, err, lib.ErrInvalidWeights)
+		}
+	}
+}
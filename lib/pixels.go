@@ -0,0 +1,178 @@
+package lib
+
+import (
+	"image"
+	"image/color"
+)
+
+// projector adds pixels of images into a grid with the projection of the accumulator,
+// remembering the last pixel so runs of the same color are projected once, and where
+// recent colors have landed so colors repeating across the image are mostly projected once too.
+// When colors are counted, call flush once done.
+type projector struct {
+	accumulator *GamutAccumulator
+	grid        *Grid
+	colors      *ColorCounts // Optional
+	cache       []projected  // Indexed by the hash of the color
+
+	last    [3]uint32 // Components of the last pixel
+	lastBin *Bin      // Bin the last pixel has landed on, nil if outside of the canvas
+	lastKey float64
+	run     uint64 // Amount of pixels of the last color not counted yet
+	started bool
+}
+
+// projected is where a color lands on the grid
+type projected struct {
+	color uint64 // Components of the color packed by packRGB, 0 for an unused entry
+	bin   int32  // Index of the bin, -1 outside of the canvas
+	key   float64
+}
+
+// projectorCacheBits is the amount of bits of the hash of colors indexing the cache of a projector
+const projectorCacheBits = 12
+
+func newProjector(accumulator *GamutAccumulator, grid *Grid, colors *ColorCounts) *projector {
+	return &projector{accumulator: accumulator, grid: grid, colors: colors, cache: make([]projected, 1<<projectorCacheBits)}
+}
+
+// packRGB packs the 16-bit components of a color into a value that is never 0
+func packRGB(r, g, b uint32) uint64 {
+	return 1<<48 | uint64(r)<<32 | uint64(g)<<16 | uint64(b)
+}
+
+// add projects a pixel into the grid
+func (p *projector) add(r, g, b uint32) {
+	if !p.started || p.last != [3]uint32{r, g, b} {
+		p.flush()
+		p.started = true
+		p.last = [3]uint32{r, g, b}
+		color := packRGB(r, g, b)
+		cached := &p.cache[(color*0x9E3779B97F4A7C15)>>(64-projectorCacheBits)]
+		if cached.color != color {
+			cached.color, cached.bin = color, -1
+			point, _, _, key, ok := p.accumulator.opts.place(p.accumulator.projection, r, g, b)
+			if ok {
+				cached.bin = int32(point.Y*p.grid.Width + point.X)
+				cached.key = key
+			}
+		}
+		p.lastBin = nil
+		if cached.bin >= 0 {
+			p.lastBin = &p.grid.Bins[cached.bin]
+			p.lastKey = cached.key
+		}
+	}
+	if p.lastBin != nil {
+		p.lastBin.Add(uint16(r), uint16(g), uint16(b), p.lastKey)
+	}
+	p.run++
+}
+
+// flush counts the run of the last color
+func (p *projector) flush() {
+	if p.colors != nil && p.run > 0 {
+		p.colors.Add(uint16(p.last[0]), uint16(p.last[1]), uint16(p.last[2]), p.run)
+	}
+	p.run = 0
+}
+
+// addRect projects the pixels of rect of img into the grid. Pixels of the common image types
+// are read straight from their Pix slices, giving the same colors as At would.
+func (p *projector) addRect(img image.Image, rect image.Rectangle) {
+	switch img := img.(type) {
+	case *image.YCbCr:
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				yi, ci := img.YOffset(x, y), img.COffset(x, y)
+				r, g, b, _ := color.YCbCr{Y: img.Y[yi], Cb: img.Cb[ci], Cr: img.Cr[ci]}.RGBA()
+				p.add(r, g, b)
+			}
+		}
+	case *image.RGBA:
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			pix := img.Pix[img.PixOffset(rect.Min.X, y):img.PixOffset(rect.Max.X, y)]
+			for i := 0; i < len(pix); i += 4 {
+				r, g, b, _ := color.RGBA{R: pix[i], G: pix[i+1], B: pix[i+2], A: pix[i+3]}.RGBA()
+				p.add(r, g, b)
+			}
+		}
+	case *image.NRGBA:
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			pix := img.Pix[img.PixOffset(rect.Min.X, y):img.PixOffset(rect.Max.X, y)]
+			for i := 0; i < len(pix); i += 4 {
+				r, g, b, _ := color.NRGBA{R: pix[i], G: pix[i+1], B: pix[i+2], A: pix[i+3]}.RGBA()
+				p.add(r, g, b)
+			}
+		}
+	case *image.RGBA64:
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			pix := img.Pix[img.PixOffset(rect.Min.X, y):img.PixOffset(rect.Max.X, y)]
+			for i := 0; i < len(pix); i += 8 {
+				p.add(uint32(pix[i])<<8|uint32(pix[i+1]),
+					uint32(pix[i+2])<<8|uint32(pix[i+3]),
+					uint32(pix[i+4])<<8|uint32(pix[i+5]))
+			}
+		}
+	case *image.NRGBA64:
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			pix := img.Pix[img.PixOffset(rect.Min.X, y):img.PixOffset(rect.Max.X, y)]
+			for i := 0; i < len(pix); i += 8 {
+				r, g, b, _ := color.NRGBA64{
+					R: uint16(pix[i])<<8 | uint16(pix[i+1]),
+					G: uint16(pix[i+2])<<8 | uint16(pix[i+3]),
+					B: uint16(pix[i+4])<<8 | uint16(pix[i+5]),
+					A: uint16(pix[i+6])<<8 | uint16(pix[i+7]),
+				}.RGBA()
+				p.add(r, g, b)
+			}
+		}
+	case *image.Gray:
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for _, gray := range img.Pix[img.PixOffset(rect.Min.X, y):img.PixOffset(rect.Max.X, y)] {
+				v := uint32(gray) * 0x101
+				p.add(v, v, v)
+			}
+		}
+	case *image.Gray16:
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			pix := img.Pix[img.PixOffset(rect.Min.X, y):img.PixOffset(rect.Max.X, y)]
+			for i := 0; i < len(pix); i += 2 {
+				v := uint32(pix[i])<<8 | uint32(pix[i+1])
+				p.add(v, v, v)
+			}
+		}
+	case *image.Paletted:
+		palette := make([][3]uint32, len(img.Palette))
+		for i, c := range img.Palette {
+			palette[i][0], palette[i][1], palette[i][2], _ = c.RGBA()
+		}
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for _, index := range img.Pix[img.PixOffset(rect.Min.X, y):img.PixOffset(rect.Max.X, y)] {
+				if int(index) >= len(palette) {
+					continue // At would panic for indices out of the palette
+				}
+				p.add(palette[index][0], palette[index][1], palette[index][2])
+			}
+		}
+	default:
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				r, g, b, _ := img.At(x, y).RGBA()
+				p.add(r, g, b)
+			}
+		}
+	}
+}
+
+// addSampled projects the pixels of rect of img picked by s into the grid
+func (p *projector) addSampled(img image.Image, rect image.Rectangle, s sampler) {
+	for y := s.first(rect.Min.Y, s.origin.Y); y < rect.Max.Y; y += s.stride {
+		for x := s.first(rect.Min.X, s.origin.X); x < rect.Max.X; x += s.stride {
+			if s.picks(x, y) {
+				r, g, b, _ := img.At(x, y).RGBA()
+				p.add(r, g, b)
+			}
+		}
+	}
+}
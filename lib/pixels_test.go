@@ -0,0 +1,195 @@
+package lib_test
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/fogleman/gg"
+	"github.com/lucasb-eyer/go-colorful"
+	"github.com/zzwx/gamutmask/lib"
+	"github.com/zzwx/gamutmask/lib/gamuttest"
+)
+
+// TestAddPix checks reading pixels of the common image types straight from Pix collects the same bins
+// as reading them through At, including for sub-images not starting at the beginning of Pix
+func TestAddPix(t *testing.T) {
+	photo := gamuttest.Photo(150, 100)
+	for _, model := range []color.Model{nil, color.RGBAModel, color.NRGBAModel, color.RGBA64Model,
+		color.NRGBA64Model, color.GrayModel, color.Gray16Model} {
+		var img image.Image = photo
+		if model != nil {
+			img = gamuttest.Converted(photo, model)
+		}
+		sub := img.(interface {
+			SubImage(r image.Rectangle) image.Image
+		}).SubImage(image.Rect(13, 7, 141, 93))
+		for _, img := range []image.Image{img, sub} {
+			pix, err := lib.NewGamutAccumulatorWithOptions(lib.DefaultOptions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			at, err := lib.NewGamutAccumulatorWithOptions(lib.DefaultOptions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pix.Add(img)
+			at.Add(gamuttest.Opaque(img))
+			if !reflect.DeepEqual(pix.Grid(), at.Grid()) {
+				t.Errorf("%T %v: bins differ", img, img.Bounds())
+			}
+		}
+	}
+}
+
+// benchmarkOptions project on a single goroutine, so the benchmarks measure the cost of a pixel
+var benchmarkOptions = lib.Options{Width: 250, Height: 250, PaddingX: 2, PaddingY: 2, Workers: 1}
+
+// smoothPhoto is a JPEG of smooth gradients, repeating colors the way most photos do unlike the noise of gamuttest.Photo
+func smoothPhoto(tb testing.TB, width, height int) image.Image {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, gamuttest.HSVSweep(width, height, 0.8), &jpeg.Options{Quality: 90}); err != nil {
+		tb.Fatal(err)
+	}
+	img, err := jpeg.Decode(&buf)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return img
+}
+
+// BenchmarkAdd compares reading pixels of the common image types straight from Pix with reading them through At
+func BenchmarkAdd(b *testing.B) {
+	photo := gamuttest.Photo(800, 600)
+	for _, model := range []struct {
+		name  string
+		model color.Model
+	}{
+		{"YCbCr", nil},
+		{"RGBA", color.RGBAModel},
+		{"NRGBA", color.NRGBAModel},
+		{"RGBA64", color.RGBA64Model},
+		{"NRGBA64", color.NRGBA64Model},
+		{"Gray", color.GrayModel},
+		{"Gray16", color.Gray16Model},
+	} {
+		var img image.Image = photo
+		if model.model != nil {
+			img = gamuttest.Converted(photo, model.model)
+		}
+		for _, path := range []struct {
+			name string
+			img  image.Image
+		}{
+			{"Pix", img},
+			{"At", gamuttest.Opaque(img)},
+		} {
+			b.Run(fmt.Sprintf("%s/%s", model.name, path.name), func(b *testing.B) {
+				gamuttest.BenchmarkAdd(b, path.img, benchmarkOptions)
+			})
+		}
+	}
+}
+
+// baselineWheel is the wheel as GenerateGamutMask drew it before the accumulator, projecting every pixel through At
+// and keeping the brightest color of every spot. Of the colors equally bright, the first one of the image was kept,
+// or with tieBreak the one with the highest RGB value as lib.Bin does.
+func baselineWheel(img image.Image, width, height, paddingX, paddingY int, tieBreak bool) *image.RGBA64 {
+	wheel := image.NewRGBA64(image.Rect(0, 0, width, height))
+	context := gg.NewContext(width, height)
+	context.DrawEllipse(float64(width)/2, float64(height)/2, float64(width)/2, float64(height)/2)
+	context.SetRGB(0, 0, 0)
+	context.Fill()
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			wheel.Set(x, y, context.Image().At(x, y))
+		}
+	}
+
+	hsv := func(r, g, b uint32) (h, s, v float64) {
+		return colorful.Color{R: float64(r) / 0xFFFF, G: float64(g) / 0xFFFF, B: float64(b) / 0xFFFF}.Hsv()
+	}
+	bounds := img.Bounds()
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			h, s, v := hsv(r, g, b)
+			px := math.Cos(h*math.Pi/180-math.Pi/2)*s*float64(width-paddingX*2)/2.0 + float64(width)/2.0
+			py := math.Sin(h*math.Pi/180-math.Pi/2)*s*float64(height-paddingY*2)/2.0 + float64(height)/2.0
+
+			current := wheel.RGBA64At(int(px), int(py))
+			_, _, currentV := hsv(uint32(current.R), uint32(current.G), uint32(current.B))
+			higher := uint64(r)<<32|uint64(g)<<16|uint64(b) > uint64(current.R)<<32|uint64(current.G)<<16|uint64(current.B)
+			if currentV < v || (tieBreak && currentV == v && higher) {
+				wheel.SetRGBA64(int(px), int(py), color.RGBA64{uint16(r), uint16(g), uint16(b), 0xFFFF})
+			}
+		}
+	}
+	return wheel
+}
+
+// TestBaseline checks the default wheel is the one drawn before the accumulator
+// but for the choice between equally bright colors landing on the same spot
+func TestBaseline(t *testing.T) {
+	img := gamuttest.Photo(300, 200)
+	wheel := lib.GenerateGamutMask(img, 250, 250, 2, 2)
+	if differing, first, err := gamuttest.Diff(baselineWheel(img, 250, 250, 2, 2, true), wheel, 0); err != nil || differing > 0 {
+		t.Errorf("%d pixels differ, first one at %v: %v", differing, first, err)
+	}
+
+	// The first color drawn before differs from the one drawn now only by its RGB value
+	baseline := baselineWheel(img, 250, 250, 2, 2, false)
+	differing := 0
+	for y := 0; y < 250; y++ {
+		for x := 0; x < 250; x++ {
+			was, is := baseline.RGBA64At(x, y), wheel.RGBA64At(x, y)
+			if was == is {
+				continue
+			}
+			differing++
+			if max16(was.R, was.G, was.B) != max16(is.R, is.G, is.B) {
+				t.Errorf("got %v at %d, %d, was %v", is, x, y, was)
+			}
+		}
+	}
+	t.Logf("%d pixels of equally bright colors differ", differing)
+}
+
+func max16(r, g, b uint16) uint16 {
+	if g > r {
+		r = g
+	}
+	if b > r {
+		r = b
+	}
+	return r
+}
+
+// BenchmarkBaseline compares drawing the default wheel with drawing it the way it was drawn before the accumulator
+func BenchmarkBaseline(b *testing.B) {
+	for _, photo := range []struct {
+		name string
+		img  image.Image
+	}{
+		{"noisy", gamuttest.Photo(800, 600)},
+		{"smooth", smoothPhoto(b, 800, 600)},
+	} {
+		b.Run(photo.name+"/GenerateGamutMask", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := lib.GenerateGamutMaskWithOptions(photo.img, benchmarkOptions); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(photo.name+"/baseline", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				baselineWheel(photo.img, 250, 250, 2, 2, false)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package lib
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+)
+
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// pngBands reads a non-interlaced PNG image row by row. Every band of rows is unfiltered and wrapped into
+// a PNG image of its own, decoded by image/png, so the pixels are exactly the ones of the whole image.
+type pngBands struct {
+	width, height int
+	ihdr          []byte // IHDR data, with the height to be replaced for every band
+	palette       []byte // PLTE and tRNS chunks as they are written into every band
+	rowSize       int    // Bytes of a row, without the filter type
+	bytesPerPixel int
+	bandRows      int
+
+	pixels        io.ReadCloser // Decompressed filtered rows
+	row, previous []byte        // Filter type and bytes of the last row read, and of the one before
+	y             int
+}
+
+// newPNGBands reads the chunks up to the image data from r, copying what it reads into consumed
+func newPNGBands(r io.Reader, consumed *bytes.Buffer) (*pngBands, error) {
+	read := func(b []byte) error {
+		_, err := io.ReadFull(r, b)
+		consumed.Write(b)
+		return err
+	}
+	signature := make([]byte, len(pngSignature))
+	if err := read(signature); err != nil || string(signature) != pngSignature {
+		return nil, ErrStreamingUnsupported
+	}
+	p := &pngBands{}
+	for {
+		var header [8]byte
+		if err := read(header[:]); err != nil {
+			return nil, ErrStreamingUnsupported
+		}
+		length, chunkType := binary.BigEndian.Uint32(header[:4]), string(header[4:])
+		if chunkType == "IDAT" {
+			break
+		}
+		if length > 1<<24 {
+			return nil, ErrStreamingUnsupported
+		}
+		data := make([]byte, length+4) // With CRC
+		if err := read(data); err != nil {
+			return nil, ErrStreamingUnsupported
+		}
+		data = data[:length]
+		switch chunkType {
+		case "IHDR":
+			if length != 13 || data[12] != 0 { // Interlaced images can't be read by rows
+				return nil, ErrStreamingUnsupported
+			}
+			p.ihdr = data
+			p.width = int(binary.BigEndian.Uint32(data[0:4]))
+			p.height = int(binary.BigEndian.Uint32(data[4:8]))
+			channels := map[byte]int{0: 1, 2: 3, 3: 1, 4: 2, 6: 4}[data[9]]
+			bitsPerPixel := int(data[8]) * channels
+			if bitsPerPixel == 0 || p.width <= 0 || p.height <= 0 || p.width > 1<<30 || p.height > 1<<30 {
+				return nil, ErrStreamingUnsupported
+			}
+			p.rowSize = (bitsPerPixel*p.width + 7) / 8
+			p.bytesPerPixel = (bitsPerPixel + 7) / 8
+		case "PLTE", "tRNS":
+			p.palette = appendPNGChunk(p.palette, chunkType, data)
+		}
+		if p.ihdr == nil {
+			return nil, ErrStreamingUnsupported // IHDR has to be the first chunk
+		}
+	}
+	if p.ihdr == nil {
+		return nil, ErrStreamingUnsupported
+	}
+
+	// From here on the image is streamed, so the rest of r is not copied into consumed
+	var err error
+	p.pixels, err = zlib.NewReader(&pngData{r: r, remaining: binary.BigEndian.Uint32(consumed.Bytes()[consumed.Len()-8:])})
+	if err != nil {
+		return nil, fmt.Errorf("can't decode image: %w", err)
+	}
+	p.bandRows = bandBytes / (p.rowSize + 1)
+	if p.bandRows < 1 {
+		p.bandRows = 1
+	}
+	p.row = make([]byte, p.rowSize+1)
+	p.previous = make([]byte, p.rowSize+1)
+	return p, nil
+}
+
+func (p *pngBands) Bounds() image.Rectangle {
+	return image.Rect(0, 0, p.width, p.height)
+}
+
+func (p *pngBands) Next() (image.Image, error) {
+	if p.y >= p.height {
+		return nil, io.EOF
+	}
+	rows := p.bandRows
+	if rows > p.height-p.y {
+		rows = p.height - p.y
+	}
+
+	var pixels bytes.Buffer
+	w, _ := zlib.NewWriterLevel(&pixels, zlib.NoCompression)
+	for i := 0; i < rows; i++ {
+		if err := p.readRow(); err != nil {
+			return nil, err
+		}
+		w.Write([]byte{0}) // No filter, as the row is unfiltered already
+		w.Write(p.row[1:])
+	}
+	w.Close()
+
+	ihdr := append([]byte(nil), p.ihdr...)
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(rows))
+	band := []byte(pngSignature)
+	band = appendPNGChunk(band, "IHDR", ihdr)
+	band = append(band, p.palette...)
+	band = appendPNGChunk(band, "IDAT", pixels.Bytes())
+	band = appendPNGChunk(band, "IEND", nil)
+	img, err := png.Decode(bytes.NewReader(band))
+	if err != nil {
+		return nil, fmt.Errorf("can't decode image: %w", err)
+	}
+	img = moveBand(img, p.y)
+	p.y += rows
+	return img, nil
+}
+
+// readRow reads and unfilters the next row into p.row
+func (p *pngBands) readRow() error {
+	p.row, p.previous = p.previous, p.row
+	if _, err := io.ReadFull(p.pixels, p.row); err != nil {
+		return fmt.Errorf("can't decode image: not enough pixel data: %w", err)
+	}
+	previous := p.previous[1:]
+	row := p.row[1:]
+	bpp := p.bytesPerPixel
+	switch p.row[0] {
+	case 0: // None
+	case 1: // Sub
+		for i := bpp; i < len(row); i++ {
+			row[i] += row[i-bpp]
+		}
+	case 2: // Up
+		for i, up := range previous {
+			row[i] += up
+		}
+	case 3: // Average
+		for i := 0; i < bpp; i++ {
+			row[i] += previous[i] / 2
+		}
+		for i := bpp; i < len(row); i++ {
+			row[i] += uint8((int(row[i-bpp]) + int(previous[i])) / 2)
+		}
+	case 4: // Paeth
+		for i := range row {
+			var left, upLeft int
+			if i >= bpp {
+				left, upLeft = int(row[i-bpp]), int(previous[i-bpp])
+			}
+			up := int(previous[i])
+			pa, pb, pc := abs(up-upLeft), abs(left-upLeft), abs(left+up-2*upLeft)
+			switch {
+			case pa <= pb && pa <= pc:
+				row[i] += uint8(left)
+			case pb <= pc:
+				row[i] += uint8(up)
+			default:
+				row[i] += uint8(upLeft)
+			}
+		}
+	default:
+		return fmt.Errorf("can't decode image: bad filter type %d", p.row[0])
+	}
+	return nil
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// pngData reads the data of consecutive IDAT chunks of r, starting with remaining bytes of the current one
+type pngData struct {
+	r         io.Reader
+	remaining uint32
+	done      bool
+}
+
+func (d *pngData) Read(b []byte) (int, error) {
+	for d.remaining == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		var crc [4]byte
+		if _, err := io.ReadFull(d.r, crc[:]); err != nil {
+			return 0, err
+		}
+		length, chunkType, err := readPNGChunkHeader(d.r)
+		if err != nil {
+			return 0, err
+		}
+		if chunkType != "IDAT" {
+			d.done = true
+			return 0, io.EOF
+		}
+		d.remaining = length
+	}
+	if uint32(len(b)) > d.remaining {
+		b = b[:d.remaining]
+	}
+	n, err := d.r.Read(b)
+	d.remaining -= uint32(n)
+	return n, err
+}
+
+func readPNGChunkHeader(r io.Reader) (length uint32, chunkType string, err error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, "", err
+	}
+	length = binary.BigEndian.Uint32(header[:4])
+	if length > 0x7fffffff {
+		return 0, "", fmt.Errorf("bad chunk length %d", length)
+	}
+	return length, string(header[4:]), nil
+}
+
+func appendPNGChunk(b []byte, chunkType string, data []byte) []byte {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(data)))
+	b = append(b, length[:]...)
+	start := len(b)
+	b = append(b, chunkType...)
+	b = append(b, data...)
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(b[start:]))
+	return append(b, crc[:]...)
+}
@@ -0,0 +1,306 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fogleman/gg"
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// Projection places colors onto the canvas of a gamut mask.
+// It has to be safe for concurrent use, since images are projected by several goroutines.
+type Projection interface {
+	// Project maps a color with 16-bit components (as returned by color.Color's RGBA) to a point
+	// of the square from -1 to 1 in both directions, which is stretched over the canvas without
+	// the padding with y pointing down. The key decides which of the colors landing on the same
+	// spot is shown: the one with the higher key.
+	Project(r, g, b uint32) (x, y, key float64)
+}
+
+// Background is implemented by projections drawing their own background onto the empty canvas
+// instead of the black ellipse of the wheel
+type Background interface {
+	DrawBackground(context *gg.Context, opts Options)
+}
+
+// Overlay is implemented by projections drawing reference marks over the rendered colors
+type Overlay interface {
+	DrawOverlay(context *gg.Context, opts Options)
+}
+
+// Validator is implemented by projections with settings of their own, checked by Options.Validate.
+// Validate returns an *OptionsError naming the invalid setting, or any error which is wrapped in one.
+type Validator interface {
+	Validate() error
+}
+
+// ColorWheel is a Projection placing hue as an angle (with red on top going clockwise)
+// and saturation as the distance from the center
+type ColorWheel struct {
+	// Model converts a color with 16-bit components into hue in degrees, saturation from 0 to 1
+	// and the key deciding which color is shown on the same spot
+	Model func(r, g, b uint32) (h, s, key float64)
+	// HueMap optionally moves hues around the wheel, like RYBHue does. It takes and returns degrees from 0 to 360.
+	HueMap func(h float64) float64
+}
+
+// Project implements Projection
+func (w ColorWheel) Project(r, g, b uint32) (x, y, key float64) {
+	h, s, key := w.Model(r, g, b)
+	if w.HueMap != nil {
+		h = w.HueMap(h)
+	}
+	// Rotating by -math.Pi/2 so Red appears on top
+	sin, cos := math.Sincos(h*math.Pi/180 - math.Pi/2)
+	return cos * s, sin * s, key
+}
+
+// HueRectangle is a Projection unwrapping the wheel into a rectangle, so colors of low saturation aren't squeezed
+// into the center: hue goes from left to right starting with red and the saturation (or the key) from the bottom up
+type HueRectangle struct {
+	// Model converts a color with 16-bit components into hue in degrees, saturation from 0 to 1
+	// and the key from 0 to 1 deciding which color is shown on the same spot
+	Model func(r, g, b uint32) (h, s, key float64)
+	// KeyAxis puts the key (value for HSV) on the vertical axis instead of the saturation
+	KeyAxis bool
+	// HueMap optionally moves hues along the horizontal axis, like RYBHue does. It takes and returns degrees from 0 to 360.
+	HueMap func(h float64) float64
+}
+
+// Project implements Projection
+func (p HueRectangle) Project(r, g, b uint32) (x, y, key float64) {
+	h, s, key := p.Model(r, g, b)
+	if p.HueMap != nil {
+		h = p.HueMap(h)
+	}
+	if p.KeyAxis {
+		s = key
+	}
+	return h/180 - 1, 1 - s*2, key
+}
+
+// DrawBackground implements Background, filling the whole canvas with black
+func (p HueRectangle) DrawBackground(context *gg.Context, opts Options) {
+	context.DrawRectangle(0, 0, float64(opts.Width), float64(opts.Height))
+	context.SetRGB(0, 0, 0)
+	context.Fill()
+}
+
+var (
+	// HSV is the wheel of HSV hue and saturation where brighter value wins. It is the default projection.
+	HSV Projection = ColorWheel{Model: hsv}
+	// HSL is the wheel of HSL hue and saturation where higher lightness wins
+	HSL Projection = ColorWheel{Model: hsl}
+	// HSI is the wheel of HSI hue and saturation where higher intensity wins
+	HSI Projection = ColorWheel{Model: hsi}
+
+	// HueSaturation is HSV hue against saturation where brighter value wins
+	HueSaturation Projection = HueRectangle{Model: hsv}
+	// HueValue is HSV hue against value where brighter value wins
+	HueValue Projection = HueRectangle{Model: hsv, KeyAxis: true}
+)
+
+var (
+	projectionsMtx sync.RWMutex
+	projections    = map[string]Projection{
+		"hsv": HSV,
+		"hsl": HSL,
+		"hsi": HSI,
+
+		"hue-saturation": HueSaturation,
+		"hue-value":      HueValue,
+
+		"lab":   CIELAB,
+		"oklab": OKLab,
+
+		"xy":         CIExy,
+		"xy-p3":      CIExyP3,
+		"xy-rec2020": CIExyRec2020,
+
+		"hsv-lut": HSVLookup,
+	}
+)
+
+// ErrUnknownProjection is returned for projections that aren't registered under the name looked up,
+// or under any name when a name is needed
+var ErrUnknownProjection = errors.New("unknown projection")
+
+// RegisterProjection makes p available by name for ProjectionByName, replacing the projection
+// registered under the same name if any. Names are case-insensitive.
+func RegisterProjection(name string, p Projection) {
+	projectionsMtx.Lock()
+	defer projectionsMtx.Unlock()
+	projections[strings.ToLower(name)] = p
+}
+
+// ProjectionByName returns the projection registered under name, like "hsv"
+func ProjectionByName(name string) (Projection, error) {
+	projectionsMtx.RLock()
+	defer projectionsMtx.RUnlock()
+	p, ok := projections[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w %q, expecting one of: %v", ErrUnknownProjection, name, strings.Join(projectionNames(), ", "))
+	}
+	return p, nil
+}
+
+// ProjectionNames lists the names of all the registered projections in alphabetical order
+func ProjectionNames() []string {
+	projectionsMtx.RLock()
+	defer projectionsMtx.RUnlock()
+	return projectionNames()
+}
+
+// NameOfProjection returns the name p is registered under (the first one in alphabetical order
+// if there are several), or false if p isn't registered
+func NameOfProjection(p Projection) (string, bool) {
+	projectionsMtx.RLock()
+	defer projectionsMtx.RUnlock()
+	for _, name := range projectionNames() {
+		if sameProjection(projections[name], p) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// projectionName names p for error messages
+func projectionName(p Projection) string {
+	if name, ok := NameOfProjection(p); ok {
+		return name
+	}
+	return fmt.Sprintf("%T", p)
+}
+
+// sameProjection tells if a and b are values of the same type with the same fields,
+// where functions are the same when they are the same function and pointers when they point to the same value
+func sameProjection(a, b Projection) bool {
+	return sameValue(reflect.ValueOf(a), reflect.ValueOf(b))
+}
+
+func sameValue(a, b reflect.Value) bool {
+	if !a.IsValid() || !b.IsValid() {
+		return a.IsValid() == b.IsValid()
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Func, reflect.Ptr, reflect.Map, reflect.Chan, reflect.UnsafePointer:
+		return a.Pointer() == b.Pointer()
+	case reflect.Slice:
+		return a.Len() == b.Len() && a.Pointer() == b.Pointer()
+	case reflect.Interface:
+		return sameValue(a.Elem(), b.Elem())
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !sameValue(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			if !sameValue(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Bool:
+		return a.Bool() == b.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() == b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() == b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() == b.Float()
+	case reflect.Complex64, reflect.Complex128:
+		return a.Complex() == b.Complex()
+	case reflect.String:
+		return a.String() == b.String()
+	}
+	return false
+}
+
+func projectionNames() []string {
+	names := make([]string, 0, len(projections))
+	for name := range projections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hsv is the same as Hsv of go-colorful, only avoiding math.Min, math.Max and math.Mod
+// that make it the slowest part of projecting a pixel
+func hsv(r, g, b uint32) (h, s, v float64) {
+	fr, fg, fb := float64(r)/float64(0xFFFF), float64(g)/float64(0xFFFF), float64(b)/float64(0xFFFF)
+	min, v := fr, fr
+	if fg < min {
+		min = fg
+	}
+	if fb < min {
+		min = fb
+	}
+	if fg > v {
+		v = fg
+	}
+	if fb > v {
+		v = fb
+	}
+	c := v - min
+	if v != 0 {
+		s = c / v
+	}
+	if min != v {
+		if v == fr {
+			h = (fg - fb) / c // Already within (-6, 6), so math.Mod(h, 6) is h
+		}
+		if v == fg {
+			h = (fb-fr)/c + 2.0
+		}
+		if v == fb {
+			h = (fr-fg)/c + 4.0
+		}
+		h *= 60.0
+		if h < 0.0 {
+			h += 360.0
+		}
+	}
+	return h, s, v
+}
+
+func hsl(r, g, b uint32) (h, s, l float64) {
+	c := colorful.Color{
+		R: float64(r) / float64(0xFFFF),
+		G: float64(g) / float64(0xFFFF),
+		B: float64(b) / float64(0xFFFF)}
+	h, s, l = c.Hsl()
+	return
+}
+
+// hsi uses the geometric definition of hue (as opposed to the hexagonal one of HSV and HSL)
+// and the saturation relative to the intensity being the mean of the components
+func hsi(r, g, b uint32) (h, s, i float64) {
+	fr, fg, fb := float64(r)/float64(0xFFFF), float64(g)/float64(0xFFFF), float64(b)/float64(0xFFFF)
+	i = (fr + fg + fb) / 3
+	if i == 0 {
+		return 0, 0, 0
+	}
+	s = 1 - math.Min(fr, math.Min(fg, fb))/i
+	denominator := math.Sqrt((fr-fg)*(fr-fg) + (fr-fb)*(fg-fb))
+	if denominator == 0 {
+		return 0, s, i // gray
+	}
+	h = math.Acos(math.Max(-1, math.Min(1, (fr-fg+fr-fb)/2/denominator))) * 180 / math.Pi
+	if fb > fg {
+		h = 360 - h
+	}
+	return h, s, i
+}
@@ -0,0 +1,75 @@
+package lib_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/zzwx/gamutmask/lib"
+	"github.com/zzwx/gamutmask/lib/gamuttest"
+)
+
+// TestProjections checks the wheels place the primaries at the same hues (red on top going clockwise)
+// on the default 250x250 wheel with a radius of 123 pixels
+func TestProjections(t *testing.T) {
+	for _, name := range []string{"hsv", "hsl", "hsi"} {
+		projection, err := lib.ProjectionByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := lib.DefaultOptions
+		opts.Projection = projection
+		gamuttest.ExpectLandsAt(t, opts, color.RGBA{255, 0, 0, 255}, image.Pt(125, 2), 1)
+		gamuttest.ExpectLandsAt(t, opts, color.RGBA{0, 0, 255, 255}, image.Pt(18, 186), 1)
+		gamuttest.ExpectLandsAt(t, opts, color.RGBA{90, 90, 90, 255}, image.Pt(125, 125), 1)
+	}
+}
+
+// halfRight places every color halfway to the right edge
+type halfRight struct{}
+
+func (halfRight) Project(r, g, b uint32) (x, y, key float64) {
+	return 0.5, 0, float64(r + g + b)
+}
+
+func TestRegisterProjection(t *testing.T) {
+	lib.RegisterProjection("Half-Right", halfRight{})
+	projection, err := lib.ProjectionByName("half-right")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := lib.DefaultOptions
+	opts.Projection = projection
+	gamuttest.ExpectLandsAt(t, opts, color.RGBA{30, 200, 90, 255}, image.Pt(186, 125), 0)
+
+	if _, err := lib.ProjectionByName("HSV"); err != nil {
+		t.Error(err)
+	}
+	if _, err := lib.ProjectionByName("cmyk"); err == nil {
+		t.Error("got an unknown projection")
+	}
+}
+
+// TestHueRectangles checks hue goes from left to right starting with red and the saturation
+// (or the value) from the bottom up, on the default 250x250 canvas with 246 pixels in between the paddings
+func TestHueRectangles(t *testing.T) {
+	for _, test := range []struct {
+		projection lib.Projection
+		color      color.Color
+		want       image.Point
+	}{
+		{lib.HueSaturation, color.RGBA{255, 0, 0, 255}, image.Pt(2, 2)},       // Red on the left
+		{lib.HueSaturation, color.RGBA{255, 255, 0, 255}, image.Pt(43, 2)},    // Yellow at 60°
+		{lib.HueSaturation, color.RGBA{0, 255, 255, 255}, image.Pt(125, 2)},   // Cyan halfway
+		{lib.HueSaturation, color.RGBA{128, 0, 0, 255}, image.Pt(2, 2)},       // Dark red is as saturated
+		{lib.HueSaturation, color.RGBA{255, 128, 128, 255}, image.Pt(2, 125)}, // Half saturated red halfway up
+		{lib.HueSaturation, color.RGBA{90, 90, 90, 255}, image.Pt(2, 248)},    // Grays at the bottom
+		{lib.HueValue, color.RGBA{255, 0, 0, 255}, image.Pt(2, 2)},
+		{lib.HueValue, color.RGBA{128, 0, 0, 255}, image.Pt(2, 125)}, // Dark red halfway up
+		{lib.HueValue, color.RGBA{0, 0, 0, 255}, image.Pt(2, 248)},
+	} {
+		opts := lib.DefaultOptions
+		opts.Projection = test.projection
+		gamuttest.ExpectLandsAt(t, opts, test.color, test.want, 1)
+	}
+}
@@ -0,0 +1,282 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+
+	// Registering decoders of the supported input formats for image.Decode
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/tiff"
+)
+
+// Stage is a step of Render reported to a ProgressFunc
+type Stage int
+
+const (
+	StageDecode   Stage = iota // Reading and decoding the input image
+	StageGenerate              // Projecting the pixels onto the wheel
+	StageEncode                // Encoding the wheel as PNG
+	StageCount                 // Amount of stages, not reported
+)
+
+// ProgressFunc is called by Render as it goes through the stages. done is the portion of the stage
+// completed, from 0 to 1. Every stage is reported at least at its start and at its end,
+// StageGenerate is also reported every few rows of the image.
+type ProgressFunc func(stage Stage, done float64)
+
+// RunGamutSettings keep everything Render needs besides the input and output
+type RunGamutSettings struct {
+	Options
+	Progress ProgressFunc // Optional
+
+	// HistogramFileName is optional. When set, RenderFile keeps the histogram of every image (at HistogramSize)
+	// in the returned file and renders the wheel out of it for as long as it is newer than the image,
+	// so the wheel can be rendered at another size or with another Renderer without decoding the image again.
+	// The projection has to stay the same for the same file name. Not used with Sampling and Stack.
+	HistogramFileName func(inputFileName string) string
+
+	// Limits skip (or sample) images too large to handle before they are decoded
+	Limits Limits
+
+	// Streaming reads PNG and TIFF images band by band (see DecodeBands) instead of decoding them at once,
+	// so images of any height fit in memory. TIFF images are only streamed out of files (or readers
+	// implementing io.ReaderAt). Other images are decoded as usual.
+	Streaming bool
+
+	// Stack lists projections rendered one below another under the one of Options into the same output
+	// (like HueValue under HueSaturation), each with the rest of Options. Not used with HistogramFileName.
+	Stack []Projection
+
+	// Munsell is optional. When set, RenderInfo.Munsell summarizes the colors of every image in Munsell notation.
+	Munsell *MunsellRenotation
+}
+
+// DefaultRunGamutSettings are used whenever nil settings are passed
+var DefaultRunGamutSettings = RunGamutSettings{Options: DefaultOptions}
+
+// RenderInfo describes the image Render has processed
+type RenderInfo struct {
+	Format   string // Format name of the input image, like "jpeg" or "png"
+	Bounds   image.Rectangle
+	Sampling Sampling        // Sampling used, with the rate picked for the image
+	Cached   bool            // The wheel has been rendered out of the histogram kept by RenderFile
+	Munsell  *MunsellSummary // Dominant Munsell color, when RunGamutSettings.Munsell is set
+}
+
+// Render decodes an image from r, generates its gamut mask and writes it to w as PNG.
+// Rendering stops with ctx.Err() once ctx is done.
+func Render(ctx context.Context, r io.Reader, w io.Writer, settings *RunGamutSettings) (info RenderInfo, err error) {
+	if settings == nil {
+		settings = &DefaultRunGamutSettings
+	}
+	settings.report(StageDecode, 0)
+	settings, img, err := settings.limit(r)
+	if err != nil {
+		return img.info, err
+	}
+	wheel, info, err := generate(ctx, img, settings)
+	if err != nil {
+		return info, err
+	}
+	return info, encode(w, wheel, settings)
+}
+
+// RenderFile is Render reading from inputFileName and writing to outputFileName. The folder of
+// outputFileName is created if necessary and the file itself only once the wheel is ready.
+func RenderFile(ctx context.Context, inputFileName string, outputFileName string, settings *RunGamutSettings) (info RenderInfo, err error) {
+	if settings == nil {
+		settings = &DefaultRunGamutSettings
+	}
+	settings.report(StageDecode, 0)
+	f, err := os.Open(inputFileName)
+	if err != nil {
+		return info, fmt.Errorf("can't open image: %w", err)
+	}
+	defer f.Close()
+	settings, img, err := settings.limit(f)
+	if err != nil {
+		return img.info, err
+	}
+	var wheel *image.RGBA64
+	if settings.HistogramFileName != nil && settings.Sampling == (Sampling{}) && len(settings.Stack) == 0 {
+		wheel, info, err = renderHistogram(ctx, img, inputFileName, settings.HistogramFileName(inputFileName), settings)
+	} else {
+		wheel, info, err = generate(ctx, img, settings)
+	}
+	if err != nil {
+		return info, err
+	}
+
+	// Making sure directory exists
+	if err := ensureDir(filepath.Dir(outputFileName)); err != nil {
+		return info, fmt.Errorf("error ensuring directory exists: %w", err)
+	}
+	out, err := os.Create(outputFileName)
+	if err != nil {
+		return info, fmt.Errorf("error creating output file: %w", err)
+	}
+	if err := encode(out, wheel, settings); err != nil {
+		out.Close()
+		return info, err
+	}
+	return info, out.Close()
+}
+
+// Decode reads an image of any of the supported formats (JPEG, PNG and TIFF), returning the format name as well
+func Decode(r io.Reader) (img image.Image, format string, err error) {
+	img, format, err = image.Decode(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("can't decode image: %w", err)
+	}
+	return img, format, nil
+}
+
+// DecodeFile opens and decodes the image stored in fileName
+func DecodeFile(fileName string) (img image.Image, format string, err error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, "", fmt.Errorf("can't open image: %w", err)
+	}
+	defer f.Close()
+	return Decode(f)
+}
+
+func generate(ctx context.Context, img limitedImage, settings *RunGamutSettings) (wheel *image.RGBA64, info RenderInfo, err error) {
+	accumulators, info, err := collect(ctx, img, false, settings)
+	if err != nil {
+		return nil, info, err
+	}
+	info.Munsell = settings.summarize(accumulators[0].Colors())
+	wheels := make([]*image.RGBA64, len(accumulators))
+	for i, accumulator := range accumulators {
+		wheels[i] = accumulator.Render()
+	}
+	return stack(wheels), info, nil
+}
+
+// collect decodes (or streams) the image checked by settings.limit and projects it onto an accumulator for the projection
+// of settings and one for every projection of settings.Stack, at HistogramSize if histogram is set
+func collect(ctx context.Context, img limitedImage, histogram bool, settings *RunGamutSettings) (accumulators []*GamutAccumulator, info RenderInfo, err error) {
+	r, info := img.r, img.info
+	opts := settings.Options
+	if histogram {
+		opts = HistogramOptions(opts)
+	}
+	projections := append([]Projection{opts.Projection}, settings.Stack...)
+	for _, projection := range projections {
+		opts.Projection = projection
+		accumulator, err := NewGamutAccumulatorWithOptions(opts)
+		if err != nil {
+			return nil, info, fmt.Errorf("gamut mask couldn't be generated: %w", err)
+		}
+		if settings.Munsell != nil && len(accumulators) == 0 {
+			accumulator.CountColors() // For the summary
+		}
+		accumulators = append(accumulators, accumulator)
+	}
+	progress := func(done float64) {
+		settings.report(StageGenerate, done)
+	}
+
+	if settings.Streaming {
+		bands, format, rest, err := DecodeBands(r, img.ra)
+		if err == nil {
+			info = RenderInfo{Format: format, Bounds: bands.Bounds(), Sampling: settings.Sampling.Resolve(bands.Bounds())}
+			settings.report(StageDecode, 1)
+			if err := addBands(ctx, accumulators, bands, progress); err != nil {
+				return nil, info, err
+			}
+			return accumulators, info, nil
+		}
+		if !errors.Is(err, ErrStreamingUnsupported) {
+			return nil, info, err
+		}
+		if err := settings.Limits.checkMemory(img.config); err != nil {
+			return nil, info, err
+		}
+		r = rest
+	}
+
+	decoded, format, err := Decode(r)
+	if err != nil {
+		return nil, info, err
+	}
+	info = RenderInfo{Format: format, Bounds: decoded.Bounds(), Sampling: settings.Sampling.Resolve(decoded.Bounds())}
+	settings.report(StageDecode, 1)
+	if err := ctx.Err(); err != nil {
+		return nil, info, err
+	}
+	for i, accumulator := range accumulators {
+		if err := accumulator.AddContext(ctx, decoded, func(done float64) {
+			progress((float64(i) + done) / float64(len(accumulators)))
+		}); err != nil {
+			return nil, info, fmt.Errorf("gamut mask couldn't be generated: %w", err)
+		}
+	}
+	return accumulators, info, nil
+}
+
+// summarize returns the Munsell summary of the colors counted, or nil without renotation data
+func (settings *RunGamutSettings) summarize(colors *ColorCounts) *MunsellSummary {
+	if settings.Munsell == nil || colors == nil {
+		return nil
+	}
+	summary := settings.Munsell.Summarize(colors)
+	return &summary
+}
+
+// stack draws images one below another
+func stack(images []*image.RGBA64) *image.RGBA64 {
+	if len(images) == 1 {
+		return images[0]
+	}
+	width, height := 0, 0
+	for _, img := range images {
+		if img.Bounds().Dx() > width {
+			width = img.Bounds().Dx()
+		}
+		height += img.Bounds().Dy()
+	}
+	stacked := image.NewRGBA64(image.Rect(0, 0, width, height))
+	y := 0
+	for _, img := range images {
+		draw.Draw(stacked, img.Bounds().Sub(img.Bounds().Min).Add(image.Pt(0, y)), img, img.Bounds().Min, draw.Src)
+		y += img.Bounds().Dy()
+	}
+	return stacked
+}
+
+func encode(w io.Writer, wheel *image.RGBA64, settings *RunGamutSettings) error {
+	settings.report(StageEncode, 0)
+	// Always using png to encode
+	if err := png.Encode(w, wheel); err != nil {
+		return fmt.Errorf("error encoding output: %w", err)
+	}
+	settings.report(StageEncode, 1)
+	return nil
+}
+
+func (settings *RunGamutSettings) report(stage Stage, done float64) {
+	if settings.Progress != nil {
+		settings.Progress(stage, done)
+	}
+}
+
+func ensureDir(dir string) error {
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		if !os.IsExist(err) { // We skip already existing dir error
+			return fmt.Errorf("error creating directory: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+package lib_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/tiff"
+
+	"github.com/zzwx/gamutmask/lib"
+	"github.com/zzwx/gamutmask/lib/gamuttest"
+)
+
+// tempDir creates a folder removed by the returned function
+func tempDir(t testing.TB) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "gamutmask")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// writeImage encodes img into fileName with encode
+func writeImage(t testing.TB, fileName string, img image.Image, encode func(f *os.File, img image.Image) error) {
+	t.Helper()
+	f, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encode(f, img); err != nil {
+		f.Close()
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func encodePNG(f *os.File, img image.Image) error {
+	return png.Encode(f, img)
+}
+
+func encodeTIFF(f *os.File, img image.Image) error {
+	return tiff.Encode(f, img, nil)
+}
+
+// renderFile renders inputFileName with settings and returns the wheel
+func renderFile(t testing.TB, inputFileName string, settings lib.RunGamutSettings) (image.Image, lib.RenderInfo) {
+	t.Helper()
+	outputFileName := inputFileName + ".png"
+	info, err := lib.RenderFile(context.Background(), inputFileName, outputFileName, &settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(outputFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	wheel, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wheel, info
+}
+
+func TestRender(t *testing.T) {
+	img := gamuttest.HSVSweep(300, 200, 0.7)
+	var in, out bytes.Buffer
+	if err := png.Encode(&in, img); err != nil {
+		t.Fatal(err)
+	}
+	var stages []lib.Stage
+	settings := lib.RunGamutSettings{Options: lib.DefaultOptions, Progress: func(stage lib.Stage, done float64) {
+		if len(stages) == 0 || stages[len(stages)-1] != stage {
+			stages = append(stages, stage)
+		}
+	}}
+	info, err := lib.Render(context.Background(), &in, &out, &settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != "png" || info.Bounds != img.Bounds() {
+		t.Errorf("got %+v, want a 300x200 png", info)
+	}
+	if len(stages) != int(lib.StageCount) || stages[0] != lib.StageDecode || stages[len(stages)-1] != lib.StageEncode {
+		t.Errorf("got stages %v reported", stages)
+	}
+	wheel, err := png.Decode(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := lib.GenerateGamutMaskWithOptions(img, lib.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSameWheels(t, want, wheel)
+
+	if _, err := lib.Render(context.Background(), strings.NewReader("not an image"), &out, nil); err == nil {
+		t.Error("rendered something that is not an image")
+	}
+}
+
+func TestRenderFile(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	img := gamuttest.HSVSweep(300, 200, 0.7)
+	input := filepath.Join(dir, "sweep.png")
+	writeImage(t, input, img, encodePNG)
+
+	wheel, info := renderFile(t, input, lib.DefaultRunGamutSettings)
+	if info.Format != "png" || info.Bounds != img.Bounds() {
+		t.Errorf("got %+v, want a 300x200 png", info)
+	}
+	want, err := lib.GenerateGamutMaskWithOptions(img, lib.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSameWheels(t, want, wheel)
+
+	// Creating the folder of the output
+	output := filepath.Join(dir, "out", "sweep.png")
+	if _, err := lib.RenderFile(context.Background(), input, output, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRenderStreaming(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	photo := gamuttest.Photo(300, 200)
+	for _, test := range []struct {
+		name   string
+		img    image.Image
+		encode func(f *os.File, img image.Image) error
+	}{
+		{"photo.png", photo, encodePNG},
+		{"photo16.png", gamuttest.Converted(photo, color.NRGBA64Model), encodePNG},
+		{"photo.tif", photo, encodeTIFF},
+	} {
+		fileName := filepath.Join(dir, test.name)
+		writeImage(t, fileName, test.img, test.encode)
+
+		// Making sure the image is streamed
+		f, err := os.Open(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, _, err = lib.DecodeBands(f, f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		settings := lib.RunGamutSettings{Options: lib.DefaultOptions}
+		decoded, _ := renderFile(t, fileName, settings)
+		settings.Streaming = true
+		streamed, _ := renderFile(t, fileName, settings)
+		if differing, first, _ := gamuttest.Diff(decoded, streamed, 0); differing > 0 {
+			t.Errorf("%s: %d pixels differ, first one at %v", test.name, differing, first)
+		}
+
+		// Streamed images don't need the memory of the whole image
+		settings.Limits.MaxMemory = 1000
+		renderFile(t, fileName, settings)
+	}
+
+	// Images which can't be streamed are left to be decoded as usual
+	if _, _, rest, err := lib.DecodeBands(bytes.NewReader([]byte("GIF89a")), nil); !errors.Is(err, lib.ErrStreamingUnsupported) || rest == nil {
+		t.Errorf("got %v, want %v along with the rest of the image", err, lib.ErrStreamingUnsupported)
+	}
+}
+
+// setStripByteCount replaces the byte count of the single strip of a little-endian TIFF image written by tiff.Encode
+func setStripByteCount(t *testing.T, data []byte, count uint32) {
+	t.Helper()
+	ifd := binary.LittleEndian.Uint32(data[4:])
+	entries := int(binary.LittleEndian.Uint16(data[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := data[int(ifd)+2+12*i:]
+		if binary.LittleEndian.Uint16(entry) == 279 { // StripByteCounts
+			binary.LittleEndian.PutUint32(entry[8:], count)
+			return
+		}
+	}
+	t.Fatal("no StripByteCounts")
+}
+
+// TestTIFFBands checks large strips are either read a few rows at a time or not streamed,
+// and that byte counts of strips aren't trusted beyond what their rows can take
+func TestTIFFBands(t *testing.T) {
+	img := gamuttest.HSVSweep(1200, 1000, 0.7) // A single strip of more than a band once decoded
+	encode := func(compression tiff.CompressionType) []byte {
+		var buf bytes.Buffer
+		if err := tiff.Encode(&buf, img, &tiff.Options{Compression: compression}); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	decoded := accumulate(t, lib.DefaultOptions, img)
+
+	uncompressed := encode(tiff.Uncompressed)
+	setStripByteCount(t, uncompressed, 0xFFFFFF00) // Only the rows are read
+	r := bytes.NewReader(uncompressed)
+	bands, _, _, err := lib.DecodeBands(r, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed := accumulate(t, lib.DefaultOptions)
+	count := 0
+	for {
+		band, err := bands.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		streamed.Add(band)
+		count++
+	}
+	if count < 2 {
+		t.Errorf("got %d bands, want the strip split", count)
+	}
+	expectSameBins(t, decoded, streamed)
+
+	compressed := encode(tiff.Deflate)
+	r = bytes.NewReader(compressed)
+	if _, _, _, err := lib.DecodeBands(r, r); !errors.Is(err, lib.ErrStreamingUnsupported) {
+		t.Errorf("got %v for a compressed strip larger than a band, want %v", err, lib.ErrStreamingUnsupported)
+	}
+
+	small := new(bytes.Buffer)
+	if err := tiff.Encode(small, gamuttest.Photo(300, 200), &tiff.Options{Compression: tiff.Deflate}); err != nil {
+		t.Fatal(err)
+	}
+	setStripByteCount(t, small.Bytes(), 0xFFFFFF00)
+	r = bytes.NewReader(small.Bytes())
+	if _, _, _, err := lib.DecodeBands(r, r); err == nil || errors.Is(err, lib.ErrStreamingUnsupported) {
+		t.Errorf("got %v for a strip of a bogus size", err)
+	}
+}
+
+func TestAddBands(t *testing.T) {
+	var buf bytes.Buffer
+	img := gamuttest.HSVSweep(300, 400, 0.7)
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	bands, _, _, err := lib.DecodeBands(bytes.NewReader(buf.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed, decoded := accumulate(t, lib.DefaultOptions), accumulate(t, lib.DefaultOptions)
+	streamed.CountColors()
+	decoded.CountColors()
+	if err := streamed.AddBands(context.Background(), bands, nil); err != nil {
+		t.Fatal(err)
+	}
+	decoded.Add(img)
+	expectSameBins(t, decoded, streamed)
+	if !reflect.DeepEqual(decoded.Colors(), streamed.Colors()) {
+		t.Error("colors counted differ")
+	}
+}
+
+func TestRenderStack(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	fileName := filepath.Join(dir, "photo.png")
+	photo := gamuttest.Photo(300, 200)
+	writeImage(t, fileName, photo, encodePNG)
+
+	saturation, value := lib.DefaultOptions, lib.DefaultOptions
+	saturation.Projection, value.Projection = lib.HueSaturation, lib.HueValue
+	for _, streaming := range []bool{false, true} {
+		settings := lib.RunGamutSettings{Options: saturation, Stack: []lib.Projection{lib.HueValue}, Streaming: streaming}
+		stacked, _ := renderFile(t, fileName, settings)
+		if got, want := stacked.Bounds(), image.Rect(0, 0, 250, 500); got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for i, opts := range []lib.Options{saturation, value} {
+			wheel, err := lib.GenerateGamutMaskWithOptions(photo, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			part := stacked.(interface {
+				SubImage(r image.Rectangle) image.Image
+			}).SubImage(image.Rect(0, i*250, 250, (i+1)*250))
+			if differing, first, _ := gamuttest.Diff(wheel, part, 0); differing > 0 {
+				t.Errorf("%v of the stack: %d pixels differ, first one at %v", opts.Projection, differing, first)
+			}
+		}
+	}
+}
+
+func TestRenderCancelled(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	input, output := filepath.Join(dir, "sweep.png"), filepath.Join(dir, "out", "sweep.png")
+	writeImage(t, input, gamuttest.HSVSweep(300, 200, 0.7), encodePNG)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, streaming := range []bool{false, true} {
+		settings := lib.RunGamutSettings{Options: lib.DefaultOptions, Streaming: streaming}
+		if _, err := lib.RenderFile(ctx, input, output, &settings); !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, want %v", err, context.Canceled)
+		}
+		if _, err := os.Stat(output); !os.IsNotExist(err) {
+			t.Errorf("output of a cancelled render exists: %v", err)
+		}
+	}
+}
+
+func TestRenderFileSampling(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	input := filepath.Join(dir, "sweep.png")
+	writeImage(t, input, gamuttest.HSVSweep(300, 200, 0.7), encodePNG)
+	settings := lib.RunGamutSettings{Options: lib.DefaultOptions}
+	settings.Sampling = lib.Sampling{MaxSamples: 6000, Seed: 3}
+
+	first, info := renderFile(t, input, settings)
+	if want := (lib.Sampling{Rate: 0.1, Seed: 3, MaxSamples: 6000}); info.Sampling != want {
+		t.Errorf("got %+v recorded, want %+v", info.Sampling, want)
+	}
+	again, _ := renderFile(t, input, settings)
+	expectSameWheels(t, first, again)
+}
+
+func TestRenderLimits(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, gamuttest.Photo(300, 200)); err != nil {
+		t.Fatal(err)
+	}
+	settings := lib.RunGamutSettings{Options: lib.DefaultOptions, Limits: lib.Limits{MaxPixels: 1000}}
+	_, err := lib.Render(context.Background(), bytes.NewReader(buf.Bytes()), ioutil.Discard, &settings)
+	var skip *lib.SkipError
+	if !errors.As(err, &skip) || !errors.Is(err, lib.ErrTooManyPixels) {
+		t.Errorf("got %v, want a *SkipError of %v", err, lib.ErrTooManyPixels)
+	}
+
+	settings.Limits.SampleOversized = true
+	info, err := lib.Render(context.Background(), bytes.NewReader(buf.Bytes()), ioutil.Discard, &settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Sampling.MaxSamples != 1000 {
+		t.Errorf("got %+v, want at most 1000 samples", info.Sampling)
+	}
+
+	// Images too large to decode are skipped even when sampling
+	settings.Limits.MaxMemory = 1000
+	_, err = lib.Render(context.Background(), bytes.NewReader(buf.Bytes()), ioutil.Discard, &settings)
+	if !errors.As(err, &skip) || !errors.Is(err, lib.ErrTooMuchMemory) {
+		t.Errorf("got %v, want a *SkipError of %v", err, lib.ErrTooMuchMemory)
+	}
+}
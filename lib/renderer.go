@@ -0,0 +1,133 @@
+package lib
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Renderer draws the bins of a Grid onto the canvas that already has the background drawn.
+// The canvas is of the same size as the grid.
+type Renderer interface {
+	Render(grid *Grid, canvas *image.RGBA64)
+}
+
+// BrightestRenderer draws the color with the highest key (the brightest one for HSV) of every bin.
+// Bins with all the samples having zero key (black for HSV) are not drawn. It is the default renderer.
+type BrightestRenderer struct{}
+
+// Render implements Renderer
+func (BrightestRenderer) Render(grid *Grid, canvas *image.RGBA64) {
+	for i := range grid.Bins {
+		current := &grid.Bins[i]
+		if current.Count > 0 && current.MaxKey > 0 {
+			setOpaque(canvas, grid, i, current.R, current.G, current.B)
+		}
+	}
+}
+
+// AverageRenderer draws the average color of the samples of every bin
+type AverageRenderer struct{}
+
+// Render implements Renderer
+func (AverageRenderer) Render(grid *Grid, canvas *image.RGBA64) {
+	for i := range grid.Bins {
+		current := &grid.Bins[i]
+		if current.Count > 0 {
+			setOpaque(canvas, grid, i,
+				uint16(current.SumR/current.Count),
+				uint16(current.SumG/current.Count),
+				uint16(current.SumB/current.Count))
+		}
+	}
+}
+
+// DensityRenderer draws the amount of samples of every bin as a shade of gray on a logarithmic scale,
+// white being the most populated bin
+type DensityRenderer struct{}
+
+// Render implements Renderer
+func (DensityRenderer) Render(grid *Grid, canvas *image.RGBA64) {
+	var maxCount uint64
+	for i := range grid.Bins {
+		if grid.Bins[i].Count > maxCount {
+			maxCount = grid.Bins[i].Count
+		}
+	}
+	scale := math.Log1p(float64(maxCount))
+	for i := range grid.Bins {
+		current := &grid.Bins[i]
+		if current.Count > 0 {
+			gray := uint16(math.Log1p(float64(current.Count)) / scale * 0xFFFF)
+			setOpaque(canvas, grid, i, gray, gray, gray)
+		}
+	}
+}
+
+// setOpaque writes an opaque color straight into the pixel of canvas under the bin at index i of grid
+func setOpaque(canvas *image.RGBA64, grid *Grid, i int, r, g, b uint16) {
+	offset := canvas.PixOffset(canvas.Rect.Min.X+i%grid.Width, canvas.Rect.Min.Y+i/grid.Width)
+	pix := canvas.Pix[offset : offset+8 : offset+8]
+	pix[0], pix[1] = uint8(r>>8), uint8(r)
+	pix[2], pix[3] = uint8(g>>8), uint8(g)
+	pix[4], pix[5] = uint8(b>>8), uint8(b)
+	pix[6], pix[7] = 0xFF, 0xFF
+}
+
+// copyRGBA copies src into dst of the same size, turning 8-bit components into 16-bit ones the way RGBA does
+func copyRGBA(dst *image.RGBA64, src *image.RGBA) {
+	for y := 0; y < src.Rect.Dy(); y++ {
+		from := src.Pix[src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+y):src.PixOffset(src.Rect.Max.X, src.Rect.Min.Y+y)]
+		to := dst.Pix[dst.PixOffset(dst.Rect.Min.X, dst.Rect.Min.Y+y):]
+		for i, v := range from {
+			to[i*2], to[i*2+1] = v, v
+		}
+	}
+}
+
+var (
+	renderersMtx sync.RWMutex
+	renderers    = map[string]Renderer{
+		"brightest": BrightestRenderer{},
+		"average":   AverageRenderer{},
+		"density":   DensityRenderer{},
+	}
+)
+
+// RegisterRenderer makes r available by name for RendererByName, replacing the renderer
+// registered under the same name if any. Names are case-insensitive.
+func RegisterRenderer(name string, r Renderer) {
+	renderersMtx.Lock()
+	defer renderersMtx.Unlock()
+	renderers[strings.ToLower(name)] = r
+}
+
+// RendererByName returns the renderer registered under name, like "brightest"
+func RendererByName(name string) (Renderer, error) {
+	renderersMtx.RLock()
+	defer renderersMtx.RUnlock()
+	r, ok := renderers[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown renderer %q, expecting one of: %v", name, strings.Join(rendererNames(), ", "))
+	}
+	return r, nil
+}
+
+// RendererNames lists the names of all the registered renderers in alphabetical order
+func RendererNames() []string {
+	renderersMtx.RLock()
+	defer renderersMtx.RUnlock()
+	return rendererNames()
+}
+
+func rendererNames() []string {
+	names := make([]string, 0, len(renderers))
+	for name := range renderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
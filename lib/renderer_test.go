@@ -0,0 +1,82 @@
+package lib_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/zzwx/gamutmask/lib"
+	"github.com/zzwx/gamutmask/lib/gamuttest"
+)
+
+// twoShades is an image of two shades of the same hue and saturation, landing on the same spot:
+// three pixels of the darker one and one of the brighter one
+func twoShades() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.NRGBA{100, 50, 50, 255})
+	img.Set(1, 0, color.NRGBA{100, 50, 50, 255})
+	img.Set(0, 1, color.NRGBA{100, 50, 50, 255})
+	img.Set(1, 1, color.NRGBA{200, 100, 100, 255})
+	return img
+}
+
+func TestGrid(t *testing.T) {
+	accumulator := lib.NewGamutAccumulator(250, 250, 2, 2)
+	accumulator.Add(twoShades())
+	grid := accumulator.Grid()
+	if grid.Width != 250 || grid.Height != 250 || len(grid.Bins) != 250*250 {
+		t.Fatalf("got a grid of %dx%d with %d bins", grid.Width, grid.Height, len(grid.Bins))
+	}
+	var bins []lib.Bin
+	for _, bin := range grid.Bins {
+		if bin.Count > 0 {
+			bins = append(bins, bin)
+		}
+	}
+	if len(bins) != 1 {
+		t.Fatalf("got %d bins, want 1", len(bins))
+	}
+	bin := bins[0]
+	if bin.Count != 4 || bin.SumR != (3*100+200)*0x101 || bin.SumG != (3*50+100)*0x101 || bin.SumB != (3*50+100)*0x101 {
+		t.Errorf("got %+v, want the sums of 4 samples", bin)
+	}
+	if bin.R != 200*0x101 || bin.G != 100*0x101 || bin.B != 100*0x101 {
+		t.Errorf("got %+v, want the brightest color kept", bin)
+	}
+	if !(bin.MinKey < bin.MaxKey) {
+		t.Errorf("got keys from %v to %v", bin.MinKey, bin.MaxKey)
+	}
+}
+
+func TestRenderers(t *testing.T) {
+	accumulator := lib.NewGamutAccumulator(250, 250, 2, 2)
+	accumulator.Add(twoShades())
+	background, err := gamuttest.Background(lib.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := lib.DefaultOptions.PointOf(color.NRGBA{200, 100, 100, 255})
+	for _, test := range []struct {
+		name string
+		want color.Color
+	}{
+		{"brightest", color.NRGBA{200, 100, 100, 255}},
+		{"Average", color.RGBA64{125 * 0x101, 62*0x101 + 0x80, 62*0x101 + 0x80, 0xFFFF}},
+		{"density", color.White}, // The most populated bin
+	} {
+		renderer, err := lib.RendererByName(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wheel := accumulator.RenderWith(renderer)
+		gamuttest.ExpectOnlyAt(t, wheel, background, p)
+		wr, wg, wb, _ := test.want.RGBA()
+		if r, g, b, _ := wheel.At(p.X, p.Y).RGBA(); r != wr || g != wg || b != wb {
+			t.Errorf("%s: got %v, want %v", test.name, wheel.At(p.X, p.Y), test.want)
+		}
+	}
+
+	if _, err := lib.RendererByName("brightestest"); err == nil {
+		t.Error("got an unknown renderer")
+	}
+}
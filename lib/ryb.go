@@ -0,0 +1,22 @@
+package lib
+
+// Piecewise linear mapping of RGB hues onto the hues of the traditional red-yellow-blue wheel of painters:
+// red stays on top, orange moves from 30° to 60°, yellow from 60° to 120°, green from 120° to 180°,
+// blue stays at 240° and purple moves from 285° to 300°. Complements then match the ones of the RYB wheel:
+// red is opposite green, yellow opposite purple and orange opposite blue.
+var (
+	rybFromRGB = []float64{0, 30, 60, 120, 240, 285, 360}
+	rybHues    = []float64{0, 60, 120, 180, 240, 300, 360}
+)
+
+// RYBHue maps a hue in degrees of the RGB wheel (like the hue of HSV) onto the RYB wheel of painters.
+// It can be used as HueMap of a ColorWheel to line masks up with physical RYB wheels.
+func RYBHue(h float64) float64 {
+	for i := 1; i < len(rybFromRGB); i++ {
+		if h <= rybFromRGB[i] {
+			t := (h - rybFromRGB[i-1]) / (rybFromRGB[i] - rybFromRGB[i-1])
+			return rybHues[i-1] + t*(rybHues[i]-rybHues[i-1])
+		}
+	}
+	return h
+}
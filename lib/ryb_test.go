@@ -0,0 +1,50 @@
+package lib_test
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/zzwx/gamutmask/lib"
+	"github.com/zzwx/gamutmask/lib/gamuttest"
+)
+
+func TestRYBHue(t *testing.T) {
+	for _, test := range []struct {
+		rgb, want float64
+	}{
+		{0, 0},     // Red stays on top
+		{15, 30},   // Halfway to orange
+		{30, 60},   // Orange
+		{60, 120},  // Yellow
+		{120, 180}, // Green opposite red
+		{180, 210}, // Cyan halfway to blue
+		{240, 240}, // Blue stays
+		{285, 300}, // Purple opposite yellow
+		{300, 312},
+		{360, 360},
+	} {
+		if got := lib.RYBHue(test.rgb); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("RYBHue(%v) = %v, want %v", test.rgb, got, test.want)
+		}
+	}
+
+	// Hues keep their order around the wheel
+	previous := lib.RYBHue(0)
+	for h := 0.5; h <= 360; h += 0.5 {
+		got := lib.RYBHue(h)
+		if got <= previous {
+			t.Fatalf("RYBHue(%v) = %v, not after %v", h, got, previous)
+		}
+		previous = got
+	}
+
+	// Complements of painters land opposite one another
+	opts := lib.DefaultOptions
+	opts.Projection = lib.ColorWheel{Model: lib.HSV.(lib.ColorWheel).Model, HueMap: lib.RYBHue}
+	gamuttest.ExpectLandsAt(t, opts, color.RGBA{255, 0, 0, 255}, image.Pt(125, 2), 1)   // Red on top
+	gamuttest.ExpectLandsAt(t, opts, color.RGBA{0, 255, 0, 255}, image.Pt(125, 248), 1) // Green at the bottom
+	gamuttest.ExpectLandsAt(t, opts, color.RGBA{255, 255, 0, 255}, image.Pt(231, 186), 1)
+	gamuttest.ExpectLandsAt(t, opts, color.RGBA{191, 0, 255, 255}, image.Pt(18, 63), 1) // Purple opposite yellow
+}
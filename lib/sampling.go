@@ -0,0 +1,167 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Sampling describes which pixels of an image are projected, for quick previews of huge images.
+// The zero value projects every pixel.
+//
+// The picked pixels only depend on the sampling and the bounds of the image,
+// so the same image sampled the same way always gives the same wheel.
+type Sampling struct {
+	Stride int     // Every Stride-th pixel of every Stride-th row is projected. Every pixel when 0 or 1.
+	Rate   float64 // Portion of the pixels left by Stride picked at random, from 0 to 1. All of them when 0.
+	Seed   int64   // Seed of the random picking
+
+	// MaxSamples lowers Rate when positive so that about MaxSamples pixels of an image are projected
+	MaxSamples int
+}
+
+// ErrInvalidSampling is wrapped by an OptionsError when the stride, the rate or the budget of Sampling is out of range
+var ErrInvalidSampling = errors.New("stride and max samples must be non-negative and rate from 0 to 1")
+
+// validate returns an error wrapping ErrInvalidSampling if s is invalid
+func (s Sampling) validate() error {
+	if s.Stride < 0 || s.MaxSamples < 0 || !(s.Rate >= 0 && s.Rate <= 1) {
+		return ErrInvalidSampling
+	}
+	return nil
+}
+
+// Resolve returns the sampling used for an image of bounds, with the rate picked for the budget of MaxSamples
+// (and 1 when every pixel left by Stride is projected)
+func (s Sampling) Resolve(bounds image.Rectangle) Sampling {
+	stride := s.stride()
+	rate := s.Rate
+	if rate == 0 {
+		rate = 1
+	}
+	candidates := float64((bounds.Dx()+stride-1)/stride) * float64((bounds.Dy()+stride-1)/stride)
+	if s.MaxSamples > 0 && candidates*rate > float64(s.MaxSamples) {
+		rate = float64(s.MaxSamples) / candidates
+	}
+	s.Rate = rate
+	return s
+}
+
+// All tells if every pixel of an image of bounds is projected
+func (s Sampling) All(bounds image.Rectangle) bool {
+	resolved := s.Resolve(bounds)
+	return resolved.stride() == 1 && resolved.Rate == 1
+}
+
+func (s Sampling) stride() int {
+	if s.Stride < 1 {
+		return 1
+	}
+	return s.Stride
+}
+
+// String returns s in the form accepted by ParseSampling
+func (s Sampling) String() string {
+	var parts []string
+	if s.Stride > 1 {
+		parts = append(parts, "stride="+strconv.Itoa(s.Stride))
+	}
+	if s.Rate != 0 && s.Rate != 1 {
+		parts = append(parts, "rate="+strconv.FormatFloat(s.Rate, 'g', 6, 64))
+	}
+	if s.Seed != 0 {
+		parts = append(parts, "seed="+strconv.FormatInt(s.Seed, 10))
+	}
+	if s.MaxSamples > 0 {
+		parts = append(parts, "max="+strconv.Itoa(s.MaxSamples))
+	}
+	if len(parts) == 0 {
+		return "all"
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseSampling parses comma-separated stride=N, rate=R, seed=N and max=N, like "stride=4" or "max=1000000,seed=7".
+// An empty string or "all" is the sampling of every pixel.
+func ParseSampling(text string) (s Sampling, err error) {
+	text = strings.TrimSpace(text)
+	if text == "" || text == "all" {
+		return s, nil
+	}
+	for _, part := range strings.Split(text, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return Sampling{}, fmt.Errorf("invalid sampling %q: expected name=value", part)
+		}
+		switch kv[0] {
+		case "stride":
+			s.Stride, err = strconv.Atoi(kv[1])
+		case "rate":
+			s.Rate, err = strconv.ParseFloat(kv[1], 64)
+		case "seed":
+			s.Seed, err = strconv.ParseInt(kv[1], 10, 64)
+		case "max":
+			s.MaxSamples, err = strconv.Atoi(kv[1])
+		default:
+			return Sampling{}, fmt.Errorf("invalid sampling %q: unknown %q", part, kv[0])
+		}
+		if err != nil {
+			return Sampling{}, fmt.Errorf("invalid sampling %q: %w", part, err)
+		}
+	}
+	if err := s.validate(); err != nil {
+		return Sampling{}, fmt.Errorf("invalid sampling %q: %w", text, err)
+	}
+	return s, nil
+}
+
+// sampler picks the pixels of an image according to a resolved Sampling
+type sampler struct {
+	origin    image.Point
+	stride    int
+	all       bool   // Every pixel left by stride is picked
+	threshold uint64 // Pixels which hash is below threshold are picked
+	seed      uint64
+}
+
+func newSampler(s Sampling, bounds image.Rectangle) sampler {
+	s = s.Resolve(bounds)
+	threshold := math.Ldexp(s.Rate, 64)
+	all := threshold >= math.Ldexp(1, 64)
+	if all {
+		threshold = 0
+	}
+	return sampler{
+		origin:    bounds.Min,
+		stride:    s.stride(),
+		all:       all,
+		threshold: uint64(threshold),
+		seed:      uint64(s.Seed),
+	}
+}
+
+// picks tells if the pixel at x, y is projected
+func (s sampler) picks(x, y int) bool {
+	if s.all {
+		return true
+	}
+	// SplitMix64 finalizer of the position and the seed
+	h := s.seed ^ (uint64(uint32(x))<<32 | uint64(uint32(y)))
+	h += 0x9E3779B97F4A7C15
+	h = (h ^ (h >> 30)) * 0xBF58476D1CE4E5B9
+	h = (h ^ (h >> 27)) * 0x94D049BB133111EB
+	h ^= h >> 31
+	return h < s.threshold
+}
+
+// first returns the first coordinate from min on the stride grid starting at origin
+func (s sampler) first(min, origin int) int {
+	offset := (min - origin) % s.stride
+	if offset == 0 {
+		return min
+	}
+	return min + s.stride - offset
+}
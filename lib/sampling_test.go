@@ -0,0 +1,91 @@
+package lib_test
+
+import (
+	"errors"
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/zzwx/gamutmask/lib"
+	"github.com/zzwx/gamutmask/lib/gamuttest"
+)
+
+func TestParseSampling(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		want lib.Sampling
+	}{
+		{"", lib.Sampling{}},
+		{"all", lib.Sampling{}},
+		{"stride=4", lib.Sampling{Stride: 4}},
+		{"rate=0.25,seed=7", lib.Sampling{Rate: 0.25, Seed: 7}},
+		{" max=1000000 , seed=-3", lib.Sampling{MaxSamples: 1000000, Seed: -3}},
+		{"stride=2,rate=0.5,seed=1,max=10", lib.Sampling{Stride: 2, Rate: 0.5, Seed: 1, MaxSamples: 10}},
+	} {
+		got, err := lib.ParseSampling(test.text)
+		if err != nil {
+			t.Errorf("%q: %v", test.text, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%q: got %+v, want %+v", test.text, got, test.want)
+		}
+		if again, err := lib.ParseSampling(got.String()); err != nil || again != got {
+			t.Errorf("%q: got %+v, %v parsing %q back", test.text, again, err, got.String())
+		}
+	}
+	for _, text := range []string{"stride", "speed=2", "stride=x", "rate=2", "stride=-1", "max=-5"} {
+		if _, err := lib.ParseSampling(text); err == nil {
+			t.Errorf("%q parsed", text)
+		}
+	}
+	if _, err := lib.ParseSampling("rate=1.5"); !errors.Is(err, lib.ErrInvalidSampling) {
+		t.Errorf("got %v, want %v", err, lib.ErrInvalidSampling)
+	}
+}
+
+// sampled returns the bins of img collected with sampling and the amount of samples projected
+func sampled(t *testing.T, img image.Image, sampling lib.Sampling, workers int) (*lib.Grid, uint64) {
+	t.Helper()
+	opts := lib.DefaultOptions
+	opts.Sampling, opts.Workers = sampling, workers
+	accumulator, err := lib.NewGamutAccumulatorWithOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accumulator.Add(img)
+	var samples uint64
+	for _, bin := range accumulator.Grid().Bins {
+		samples += bin.Count
+	}
+	return accumulator.Grid(), samples
+}
+
+func TestSamplingReproducible(t *testing.T) {
+	img := gamuttest.HSVSweep(300, 200, 0.8)
+	random := lib.Sampling{Rate: 0.3, Seed: 7}
+	first, samples := sampled(t, img, random, 1)
+	if samples < 300*200*25/100 || samples > 300*200*35/100 {
+		t.Errorf("got %d samples, want about 30%%", samples)
+	}
+	if again, _ := sampled(t, img, random, 7); !reflect.DeepEqual(first, again) {
+		t.Error("bins differ between runs")
+	}
+	if other, _ := sampled(t, img, lib.Sampling{Rate: 0.3, Seed: 8}, 1); reflect.DeepEqual(first, other) {
+		t.Error("bins of another seed are the same")
+	}
+
+	if _, samples := sampled(t, img, lib.Sampling{Stride: 4}, 3); samples != 75*50 {
+		t.Errorf("got %d samples, want every 4th pixel of every 4th row", samples)
+	}
+	budget := lib.Sampling{MaxSamples: 1000}
+	if got := budget.Resolve(img.Bounds()); got.Rate != 1000.0/(300*200) {
+		t.Errorf("got %+v, want the rate of 1000 samples", got)
+	}
+	if _, samples := sampled(t, img, budget, 0); samples < 800 || samples > 1200 {
+		t.Errorf("got %d samples, want about 1000", samples)
+	}
+	if !budget.All(image.Rect(0, 0, 20, 20)) || budget.All(img.Bounds()) {
+		t.Error("budget applied to the wrong images")
+	}
+}
@@ -0,0 +1,199 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"sync"
+)
+
+// Bands is an image read band by band, each band being an image of a few full rows
+// with the bounds of these rows within the whole image
+type Bands interface {
+	Bounds() image.Rectangle
+	// Next returns the next band, or io.EOF after the last one
+	Next() (image.Image, error)
+}
+
+// ErrStreamingUnsupported is returned by DecodeBands for images that can't be read band by band:
+// formats other than PNG and TIFF, interlaced PNG and tiled or planar TIFF
+var ErrStreamingUnsupported = errors.New("image can't be streamed")
+
+// bandBytes is about the amount of bytes of pixels a band is decoded into
+const bandBytes = 4 << 20
+
+// DecodeBands starts reading a PNG or TIFF image from r band by band, so only a few rows of it are kept in memory
+// at a time. TIFF images are read through ra, which may be r itself (like an *os.File), so they can't be streamed
+// when ra is nil. Pixels of the bands are the same as the ones image.Decode returns.
+//
+// Returns ErrStreamingUnsupported for images that can't be read band by band, along with rest
+// to decode the whole image from, as the beginning of r may have been consumed.
+func DecodeBands(r io.Reader, ra io.ReaderAt) (bands Bands, format string, rest io.Reader, err error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(8)
+	switch {
+	case bytes.HasPrefix(magic, []byte(pngSignature)):
+		var consumed bytes.Buffer
+		stream, err := newPNGBands(br, &consumed)
+		if err != nil {
+			return nil, "", io.MultiReader(&consumed, br), err
+		}
+		return stream, "png", nil, nil
+	case ra != nil && (bytes.HasPrefix(magic, []byte("II*\x00")) || bytes.HasPrefix(magic, []byte("MM\x00*"))):
+		stream, err := newTIFFBands(ra)
+		if err != nil {
+			return nil, "", br, err
+		}
+		return stream, "tiff", nil, nil
+	}
+	return nil, "", br, ErrStreamingUnsupported
+}
+
+// AddBands projects every pixel of bands (or the ones picked by Options.Sampling) onto the wheel as the bands
+// are read, reporting the portion of the image done (from 0 to 1) to the optional progress function.
+// At most a band per worker (and one being read) is kept in memory at a time.
+//
+// It stops with ctx.Err() once ctx is done, in which case only a part of the image may be added.
+func (a *GamutAccumulator) AddBands(ctx context.Context, bands Bands, progress func(done float64)) error {
+	return addBands(ctx, []*GamutAccumulator{a}, bands, progress)
+}
+
+// addBands is AddBands projecting every band onto all of accumulators,
+// using the sampling and the amount of workers of the first one
+func addBands(ctx context.Context, accumulators []*GamutAccumulator, bands Bands, progress func(done float64)) error {
+	bounds := bands.Bounds()
+	opts := accumulators[0].opts
+	sampler := newSampler(opts.Sampling, bounds)
+	sampled := !opts.Sampling.All(bounds)
+	workers := opts.workers()
+
+	grids := make([][]*Grid, workers) // Grids of every worker for every accumulator
+	colors := make([][]*ColorCounts, workers)
+	queue := make(chan image.Image)
+	rowsDone := make(chan int, workers)
+	var wg sync.WaitGroup
+	for w := range grids {
+		grids[w] = make([]*Grid, len(accumulators))
+		colors[w] = make([]*ColorCounts, len(accumulators))
+		projectors := make([]*projector, len(accumulators))
+		for i, a := range accumulators {
+			if w == 0 {
+				grids[w][i], colors[w][i] = a.grid, a.colors
+			} else {
+				grids[w][i] = NewGrid(a.opts.Width, a.opts.Height)
+				if a.colors != nil {
+					colors[w][i] = NewColorCounts()
+				}
+			}
+			projectors[i] = newProjector(a, grids[w][i], colors[w][i])
+		}
+		wg.Add(1)
+		go func(projectors []*projector) {
+			defer wg.Done()
+			defer func() {
+				for _, projector := range projectors {
+					projector.flush()
+				}
+			}()
+			for band := range queue {
+				for _, projector := range projectors {
+					if sampled {
+						projector.addSampled(band, band.Bounds(), sampler)
+					} else {
+						projector.addRect(band, band.Bounds())
+					}
+				}
+				rowsDone <- band.Bounds().Dy()
+			}
+		}(projectors)
+	}
+
+	var readErr error
+	go func() {
+		defer func() {
+			close(queue)
+			wg.Wait()
+			close(rowsDone)
+		}()
+		for ctx.Err() == nil {
+			band, err := bands.Next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				readErr = err
+				return
+			}
+			queue <- band
+		}
+	}()
+
+	if progress != nil {
+		progress(0)
+	}
+	rows := 0
+	for n := range rowsDone {
+		rows += n
+		if progress != nil {
+			progress(float64(rows) / float64(bounds.Dy()))
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if readErr != nil {
+		return readErr
+	}
+	for w, worker := range grids[1:] {
+		for i, grid := range worker {
+			accumulators[i].grid.Merge(grid)
+			if accumulators[i].colors != nil {
+				accumulators[i].colors.Merge(colors[w+1][i])
+			}
+		}
+	}
+	return nil
+}
+
+// moveBand moves the bounds of a band decoded at the origin down to row y of the whole image
+func moveBand(img image.Image, y int) image.Image {
+	switch img := img.(type) {
+	case *image.Gray:
+		img.Rect = img.Rect.Add(image.Pt(0, y))
+	case *image.Gray16:
+		img.Rect = img.Rect.Add(image.Pt(0, y))
+	case *image.RGBA:
+		img.Rect = img.Rect.Add(image.Pt(0, y))
+	case *image.RGBA64:
+		img.Rect = img.Rect.Add(image.Pt(0, y))
+	case *image.NRGBA:
+		img.Rect = img.Rect.Add(image.Pt(0, y))
+	case *image.NRGBA64:
+		img.Rect = img.Rect.Add(image.Pt(0, y))
+	case *image.Paletted:
+		img.Rect = img.Rect.Add(image.Pt(0, y))
+	case *image.CMYK:
+		img.Rect = img.Rect.Add(image.Pt(0, y))
+	default:
+		return &movedImage{Image: img, dy: y}
+	}
+	return img
+}
+
+// movedImage is an image moved down by dy rows
+type movedImage struct {
+	image.Image
+	dy int
+}
+
+func (m *movedImage) Bounds() image.Rectangle {
+	return m.Image.Bounds().Add(image.Pt(0, m.dy))
+}
+
+func (m *movedImage) At(x, y int) color.Color {
+	return m.Image.At(x, y-m.dy)
+}
@@ -0,0 +1,333 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"sort"
+
+	"golang.org/x/image/tiff"
+)
+
+// TIFF tags the strips are read with
+const (
+	tiffImageWidth          = 256
+	tiffImageLength         = 257
+	tiffBitsPerSample       = 258
+	tiffCompression         = 259
+	tiffStripOffsets        = 273
+	tiffSamplesPerPixel     = 277
+	tiffRowsPerStrip        = 278
+	tiffStripByteCounts     = 279
+	tiffPlanarConfiguration = 284
+	tiffTileWidth           = 322
+)
+
+// tiffTypeSizes are the sizes of the TIFF field types, indexed by type
+var tiffTypeSizes = [...]uint32{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8}
+
+// tiffEntry is an IFD entry with its value read in the byte order of the file
+type tiffEntry struct {
+	tag, fieldType uint16
+	count          uint32
+	value          []byte
+}
+
+// tiffBands reads a stripped TIFF image a few strips at a time. Every band of strips is wrapped with the
+// IFD of the image into a TIFF image of its own, decoded by x/image/tiff, so the pixels are exactly
+// the ones of the whole image. Uncompressed strips too large for a band are read a few rows at a time.
+type tiffBands struct {
+	ra            io.ReaderAt
+	order         binary.ByteOrder
+	header        []byte
+	entries       []tiffEntry // Entries of the IFD besides the ones describing the strips
+	width, height int
+	rowsPerStrip  int
+	offsets       []uint64
+	counts        []uint64
+	stripsPerBand int
+	strip         int
+
+	// Splitting uncompressed strips into bands of bandRows rows, row being the first row of the next band
+	// within the strip
+	split         bool
+	stripRowBytes uint64
+	bandRows      int
+	row           int
+}
+
+// newTIFFBands reads the first IFD of the TIFF image of ra
+func newTIFFBands(ra io.ReaderAt) (*tiffBands, error) {
+	header := make([]byte, 8)
+	if _, err := ra.ReadAt(header, 0); err != nil {
+		return nil, ErrStreamingUnsupported
+	}
+	t := &tiffBands{ra: ra, header: header[:4]}
+	switch string(header[:4]) {
+	case "II*\x00":
+		t.order = binary.LittleEndian
+	case "MM\x00*":
+		t.order = binary.BigEndian
+	default:
+		return nil, ErrStreamingUnsupported
+	}
+
+	ifd := int64(t.order.Uint32(header[4:]))
+	var count [2]byte
+	if _, err := ra.ReadAt(count[:], ifd); err != nil {
+		return nil, ErrStreamingUnsupported
+	}
+	raw := make([]byte, 12*int(t.order.Uint16(count[:])))
+	if _, err := ra.ReadAt(raw, ifd+2); err != nil {
+		return nil, ErrStreamingUnsupported
+	}
+	bitsPerSample, samples := 1, 1
+	var compression uint64 = 1
+	for i := 0; i < len(raw); i += 12 {
+		e := tiffEntry{
+			tag:       t.order.Uint16(raw[i:]),
+			fieldType: t.order.Uint16(raw[i+2:]),
+			count:     t.order.Uint32(raw[i+4:]),
+		}
+		if int(e.fieldType) >= len(tiffTypeSizes) || e.fieldType == 0 || e.count > 1<<26 {
+			return nil, ErrStreamingUnsupported
+		}
+		e.value = make([]byte, tiffTypeSizes[e.fieldType]*e.count)
+		if len(e.value) <= 4 {
+			copy(e.value, raw[i+8:])
+		} else if _, err := ra.ReadAt(e.value, int64(t.order.Uint32(raw[i+8:]))); err != nil {
+			return nil, ErrStreamingUnsupported
+		}
+
+		switch e.tag {
+		case tiffImageWidth:
+			t.width = int(t.first(e))
+		case tiffImageLength:
+			t.height = int(t.first(e))
+			continue
+		case tiffBitsPerSample:
+			bitsPerSample = int(t.first(e))
+		case tiffSamplesPerPixel:
+			samples = int(t.first(e))
+		case tiffCompression:
+			compression = t.first(e)
+		case tiffRowsPerStrip:
+			t.rowsPerStrip = int(t.first(e))
+			continue
+		case tiffStripOffsets:
+			t.offsets = t.uints(e)
+			continue
+		case tiffStripByteCounts:
+			t.counts = t.uints(e)
+			continue
+		case tiffPlanarConfiguration:
+			if t.first(e) != 1 {
+				return nil, ErrStreamingUnsupported // Every strip holds a single sample of the pixels
+			}
+		case tiffTileWidth:
+			return nil, ErrStreamingUnsupported
+		}
+		t.entries = append(t.entries, e)
+	}
+
+	if t.width <= 0 || t.height <= 0 || t.width > 1<<30 || t.height > 1<<30 {
+		return nil, ErrStreamingUnsupported
+	}
+	if t.rowsPerStrip <= 0 || t.rowsPerStrip > t.height {
+		t.rowsPerStrip = t.height
+	}
+	strips := (t.height + t.rowsPerStrip - 1) / t.rowsPerStrip
+	if len(t.offsets) < strips || len(t.counts) < strips {
+		return nil, ErrStreamingUnsupported // Left for the decoder to report
+	}
+	t.offsets, t.counts = t.offsets[:strips], t.counts[:strips]
+
+	// Strips can't take more than their rows do, so bogus byte counts aren't read
+	t.stripRowBytes = (uint64(t.width)*uint64(bitsPerSample)*uint64(samples) + 7) / 8
+	for i, count := range t.counts {
+		stored := uint64(t.stripRows(i)) * t.stripRowBytes
+		switch {
+		case compression == 1 && count < stored:
+			return nil, ErrStreamingUnsupported // Left for the decoder to report
+		case compression == 1:
+			t.counts[i] = stored
+		case count > stored+stored/2+1024: // LZW takes at most 12 bits a byte, deflate and PackBits less
+			return nil, fmt.Errorf("can't decode image: strip %d takes %d bytes, more than its %d rows can", i, count, t.stripRows(i))
+		}
+	}
+
+	rowBytes := t.width * 4
+	if bitsPerSample*samples > 32 {
+		rowBytes *= 2
+	}
+	t.bandRows = bandBytes / rowBytes
+	if t.bandRows < 1 {
+		t.bandRows = 1
+	}
+	if t.rowsPerStrip > t.bandRows {
+		if compression != 1 {
+			return nil, ErrStreamingUnsupported // Compressed strips can't be split
+		}
+		t.split = true
+	}
+	t.stripsPerBand = t.bandRows / t.rowsPerStrip
+	if t.stripsPerBand < 1 {
+		t.stripsPerBand = 1
+	}
+	return t, nil
+}
+
+// stripRows returns the amount of rows of strip i, fewer than rowsPerStrip for the last one
+func (t *tiffBands) stripRows(i int) int {
+	if rows := t.height - i*t.rowsPerStrip; rows < t.rowsPerStrip {
+		return rows
+	}
+	return t.rowsPerStrip
+}
+
+// first returns the first value of an entry of unsigned integers
+func (t *tiffBands) first(e tiffEntry) uint64 {
+	if values := t.uints(e); len(values) > 0 {
+		return values[0]
+	}
+	return 0
+}
+
+// uints returns the values of an entry of unsigned integers
+func (t *tiffBands) uints(e tiffEntry) []uint64 {
+	values := make([]uint64, e.count)
+	for i := range values {
+		switch e.fieldType {
+		case 1: // BYTE
+			values[i] = uint64(e.value[i])
+		case 3: // SHORT
+			values[i] = uint64(t.order.Uint16(e.value[2*i:]))
+		case 4: // LONG
+			values[i] = uint64(t.order.Uint32(e.value[4*i:]))
+		default:
+			return nil
+		}
+	}
+	return values
+}
+
+// long returns an entry of LONG values
+func (t *tiffBands) long(tag uint16, values ...uint64) tiffEntry {
+	e := tiffEntry{tag: tag, fieldType: 4, count: uint32(len(values)), value: make([]byte, 4*len(values))}
+	for i, v := range values {
+		t.order.PutUint32(e.value[4*i:], uint32(v))
+	}
+	return e
+}
+
+func (t *tiffBands) Bounds() image.Rectangle {
+	return image.Rect(0, 0, t.width, t.height)
+}
+
+func (t *tiffBands) Next() (image.Image, error) {
+	if t.strip >= len(t.offsets) {
+		return nil, io.EOF
+	}
+	var (
+		y, rows, rowsPerStrip int
+		sources, counts       []uint64 // Offsets and byte counts of the strips of the band within the file
+		nextStrip, nextRow    int
+	)
+	if t.split {
+		y = t.strip*t.rowsPerStrip + t.row
+		rows = t.bandRows
+		if rows > t.stripRows(t.strip)-t.row {
+			rows = t.stripRows(t.strip) - t.row
+		}
+		rowsPerStrip = rows
+		sources = []uint64{t.offsets[t.strip] + uint64(t.row)*t.stripRowBytes}
+		counts = []uint64{uint64(rows) * t.stripRowBytes}
+		nextStrip, nextRow = t.strip, t.row+rows
+		if nextRow == t.stripRows(t.strip) {
+			nextStrip, nextRow = t.strip+1, 0
+		}
+	} else {
+		strips := t.stripsPerBand
+		if strips > len(t.offsets)-t.strip {
+			strips = len(t.offsets) - t.strip
+		}
+		y = t.strip * t.rowsPerStrip
+		rows = strips * t.rowsPerStrip
+		if rows > t.height-y {
+			rows = t.height - y
+		}
+		rowsPerStrip = t.rowsPerStrip
+		sources, counts = t.offsets[t.strip:t.strip+strips], t.counts[t.strip:t.strip+strips]
+		nextStrip = t.strip + strips
+	}
+
+	strips := len(counts)
+	entries := append([]tiffEntry(nil), t.entries...)
+	entries = append(entries,
+		t.long(tiffImageLength, uint64(rows)),
+		t.long(tiffRowsPerStrip, uint64(rowsPerStrip)),
+		t.long(tiffStripOffsets, make([]uint64, strips)...), // Filled in once the layout is known
+		t.long(tiffStripByteCounts, counts...),
+	)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	// Header, IFD, values not fitting into the IFD and finally the strips
+	offset := uint32(8 + 2 + 12*len(entries) + 4)
+	valueOffsets := make([]uint32, len(entries))
+	var offsets *tiffEntry
+	for i := range entries {
+		if entries[i].tag == tiffStripOffsets {
+			offsets = &entries[i]
+		}
+		if len(entries[i].value) > 4 {
+			valueOffsets[i] = offset
+			offset += uint32(len(entries[i].value)+1) &^ 1 // Values start on a word boundary
+		}
+	}
+	for i, count := range counts {
+		t.order.PutUint32(offsets.value[4*i:], offset)
+		offset += uint32(count)
+	}
+
+	band := bytes.NewBuffer(make([]byte, 0, offset))
+	band.Write(t.header)
+	binary.Write(band, t.order, uint32(8))
+	binary.Write(band, t.order, uint16(len(entries)))
+	for i, e := range entries {
+		binary.Write(band, t.order, e.tag)
+		binary.Write(band, t.order, e.fieldType)
+		binary.Write(band, t.order, e.count)
+		if len(e.value) > 4 {
+			binary.Write(band, t.order, valueOffsets[i])
+		} else {
+			var value [4]byte
+			copy(value[:], e.value)
+			band.Write(value[:])
+		}
+	}
+	binary.Write(band, t.order, uint32(0)) // No next IFD
+	for _, e := range entries {
+		if len(e.value) > 4 {
+			band.Write(e.value)
+			if len(e.value)%2 == 1 {
+				band.WriteByte(0)
+			}
+		}
+	}
+	for i, count := range counts {
+		data := make([]byte, count)
+		if _, err := t.ra.ReadAt(data, int64(sources[i])); err != nil {
+			return nil, fmt.Errorf("can't decode image: %w", err)
+		}
+		band.Write(data)
+	}
+
+	img, err := tiff.Decode(bytes.NewReader(band.Bytes()))
+	if err != nil {
+		return nil, fmt.Errorf("can't decode image: %w", err)
+	}
+	t.strip, t.row = nextStrip, nextRow
+	return moveBand(img, y), nil
+}
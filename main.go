@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -9,6 +10,7 @@ import (
 
 	"log"
 	"strings"
+	"sync"
 	"syscall"
 
 	"os"
@@ -28,11 +30,16 @@ const (
 func Usage() {
 	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nCommands:\n")
+	fmt.Fprintf(os.Stderr, "  collect\n        Collect gamut of images into an accumulator file\n")
+	fmt.Fprintf(os.Stderr, "  merge\n        Merge accumulator files and render them\n")
+	fmt.Fprintf(os.Stderr, "  locate\n        Highlight pixels of an image landing in a region of its gamut image\n")
+	fmt.Fprintf(os.Stderr, "  palette\n        Generate gamut image of a list of colors\n")
 }
 
 func isInputFileForProcessing(folderName, fileName string) bool {
 	switch filepath.Ext(fileName) {
-	case ".jpg", ".jpeg", ".png":
+	case ".jpg", ".jpeg", ".png", ".tif", ".tiff":
 		return true
 	}
 	return false
@@ -46,12 +53,28 @@ func beforeDelete(folderName, fileName string) bool {
 	if fileName == ".gitignore" {
 		return false
 	}
+	if strings.HasSuffix(fileName, munsellExt) {
+		// Munsell summaries go along with the gamut images
+		if _, err := os.Stat(folderName + "/" + strings.TrimSuffix(fileName, munsellExt)); err == nil {
+			return false
+		}
+	}
 	fmt.Printf("Deleting: %v\n", folderName+"/"+fileName)
 	return true
 }
 
 // main() calls ProcessChangedFilesOnly periodically
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	flag.Usage = Usage
 	var help bool
 	flag.BoolVar(&help, "help", false, "Print this help")
@@ -73,14 +96,28 @@ func main() {
 	var output string
 	flag.StringVar(&output, "output", outputDefault, "Folder name where output files should be saved")
 
-	var width int
-	var height int
-	var paddingX int
-	var paddingY int
-	flag.IntVar(&width, "width", 250, "Widgth of the resulting gamut image")
-	flag.IntVar(&height, "height", 250, "Height of the resulting gamut image")
-	flag.IntVar(&paddingX, "paddingX", 2, "Widgth of the resulting gamut image")
-	flag.IntVar(&paddingY, "paddingY", 2, "Widgth of the resulting gamut image")
+	var cache bool
+	flag.BoolVar(&cache, "cache", false, "Keep histograms of the images next to _list.json to render them at another size or style without decoding them again")
+
+	var jobs int
+	flag.IntVar(&jobs, "jobs", 1, "Amount of images processed concurrently (consider lowering -workers when more than 1)")
+
+	var hash string
+	flag.StringVar(&hash, "hash", "md5", "Hash detecting changes of the images which size or modification time have changed, one of: "+strings.Join(lib.HasherNames(), ", "))
+
+	var maxPixels, maxMemory int64
+	flag.Int64Var(&maxPixels, "maxPixels", 0, "Images with more pixels are skipped or sampled, see -oversized (0 for no limit)")
+	flag.Int64Var(&maxMemory, "maxMemory", 0, "Images estimated to take more megabytes once decoded are skipped (0 for no limit)")
+	var oversized string
+	flag.StringVar(&oversized, "oversized", "skip", "What to do with images with more than -maxPixels pixels: skip or sample")
+
+	var stream bool
+	flag.BoolVar(&stream, "stream", false, "Read PNG and TIFF images a band of rows at a time instead of decoding them whole, so -maxMemory doesn't apply to them")
+
+	var stack string
+	flag.StringVar(&stack, "stack", "", "Comma-separated spaces rendered one below another under -space into the same image, like hue-value under -space hue-saturation, with -ryb and -maxChroma applied to them as well")
+
+	optionFlags := newOptionFlags(flag.CommandLine)
 
 	flag.Parse()
 
@@ -122,18 +159,60 @@ func main() {
 	//	lib.SanitizeOutputFolder(output, isOutputFileSanitizable, &lib.FileInfoList{})
 	//}
 
-	var settings = RunGamutSettings{
-		Width:    width,
-		Height:   height,
-		PaddingX: paddingX,
-		PaddingY: paddingY,
+	options, err := optionFlags.options()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(2)
+	}
+	var settings = lib.RunGamutSettings{
+		Options: options,
+	}
+	switch oversized {
+	case "skip", "sample":
+	default:
+		fmt.Printf("Error: unknown -oversized %q\n", oversized)
+		os.Exit(2)
+	}
+	settings.Limits = lib.Limits{
+		MaxPixels:       maxPixels,
+		MaxMemory:       maxMemory << 20,
+		SampleOversized: oversized == "sample",
+	}
+	settings.Streaming = stream
+	settings.Munsell = optionFlags.renotation
+	if stack != "" {
+		for _, space := range strings.Split(stack, ",") {
+			projection, err := optionFlags.projection(strings.TrimSpace(space))
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(2)
+			}
+			settings.Stack = append(settings.Stack, projection)
+		}
+	}
+	if cache {
+		settings.HistogramFileName = func(inputFileName string) string {
+			return inputFileName + "." + optionFlags.spaceName() + histogramExt
+		}
+	}
+
+	hasher, err := lib.HasherByName(hash)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(2)
 	}
+	processOptions := lib.ProcessOptions{Jobs: jobs, Hasher: hasher, SkipKey: settings.Limits.String()}
 
 	if monitor {
 		fmt.Println("Monitoring:", input, "for new and updated images...")
 
 		watcher := newWatcher(input, recursive)
 
+		// ctx is cancelled on termination to stop the rendering in progress,
+		// while processing is held for as long as executeProcess runs
+		ctx, cancel := context.WithCancel(context.Background())
+		var processing sync.Mutex
+
 		timer := time.NewTimer(time.Second * 1)
 		fallbackTimer := time.NewTimer(time.Second * 10)
 		restart := make(chan int)
@@ -142,8 +221,8 @@ func main() {
 				select {
 				case event := <-watcher.Events:
 					if event.Name /*relativePath*/ != "" {
-						if filepath.Base(event.Name) == "_list.json" {
-							// Skip _list.json changes to avoid non-stopping changes
+						if filepath.Base(event.Name) == "_list.json" || filepath.Ext(event.Name) == histogramExt {
+							// Skip _list.json and histogram changes to avoid non-stopping changes
 						} else {
 							if recursive {
 								restart <- 0
@@ -155,7 +234,9 @@ func main() {
 				case <-watcher.Errors:
 					// Skip the errors
 				case <-timer.C:
-					executeProcess(recursive, input, output, settings)
+					processing.Lock()
+					executeProcess(ctx, recursive, input, output, settings, processOptions)
+					processing.Unlock()
 				case <-fallbackTimer.C:
 					resetTimer(timer, time.Nanosecond)
 					resetTimer(fallbackTimer, time.Second*10)
@@ -169,7 +250,9 @@ func main() {
 			for {
 				select {
 				case <-c:
-					// TODO Wait for operations to finish gracefully
+					// Stopping the rendering in progress and waiting for the manifest to be saved
+					cancel()
+					processing.Lock()
 					fmt.Println("\nStopped Monitoring:", input)
 					os.Exit(0) // We consider it a valid termination
 				case <-restart:
@@ -182,7 +265,7 @@ func main() {
 		<-make(chan int) // Blocking main() forever
 
 	} else {
-		executeProcess(recursive, input, output, settings)
+		executeProcess(context.Background(), recursive, input, output, settings, processOptions)
 	}
 
 }
@@ -214,30 +297,73 @@ func addToWatcher(watcher *fsnotify.Watcher, input string, isRecursive bool) {
 	}
 }
 
+// histogramExt ends the names of the histograms kept with -cache, unlike the ".gamut" of accumulator files
+const histogramExt = ".gamuthist"
+
 var outputFileName = func(inputFileName string) string {
 	return inputFileName + ".png" // Simply appending .png at the end
 }
 
-func executeProcess(recursive bool, input string, output string, settings RunGamutSettings) {
+func executeProcess(ctx context.Context, recursive bool, input string, output string, settings lib.RunGamutSettings, opts lib.ProcessOptions) {
+	processFile := RunGamutFuncGen(ctx, &settings)
+	if opts.Jobs > 1 {
+		processFile = RunGamutFuncGenConcurrent(ctx, &settings)
+	}
 	if recursive {
-		lib.ProcessChangedFilesOnlyRecursively(input,
+		lib.ProcessChangedFilesOnlyRecursivelyWithOptions(input,
 			output,
 			outputFileName,
 			isInputFileForProcessing,
 			func(inputFolderName string) string {
 				return inputFolderName + "/_list.json"
 			},
-			RunGamutFuncGen(&settings),
-			beforeDelete)
+			processFile,
+			beforeDelete,
+			opts)
 	} else {
-		lib.ProcessChangedFilesOnly(
+		lib.ProcessChangedFilesOnlyWithOptions(
 			input,
 			output,
 			outputFileName,
 			isInputFileForProcessing,
 			input+"/_list.json",
-			RunGamutFuncGen(&settings),
-			beforeDelete)
+			processFile,
+			beforeDelete,
+			opts)
+	}
+	if settings.HistogramFileName != nil {
+		removeStaleHistograms(input, recursive)
+	}
+}
+
+// removeStaleHistograms deletes the histograms kept with -cache next to images that no longer exist
+func removeStaleHistograms(folderName string, recursive bool) {
+	files, err := ioutil.ReadDir(folderName)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			if recursive {
+				removeStaleHistograms(folderName+"/"+f.Name(), recursive)
+			}
+			continue
+		}
+		if filepath.Ext(f.Name()) != histogramExt {
+			continue
+		}
+		// Histograms are named after the image followed by the space, like "photo.jpg.hsv.gamuthist"
+		inputName := strings.TrimSuffix(f.Name(), histogramExt)
+		for filepath.Ext(inputName) != "" && !isInputFileForProcessing(folderName, inputName) {
+			inputName = strings.TrimSuffix(inputName, filepath.Ext(inputName))
+		}
+		if !isInputFileForProcessing(folderName, inputName) {
+			continue // Not a histogram
+		}
+		if _, err := os.Stat(folderName + "/" + inputName); os.IsNotExist(err) {
+			fmt.Printf("Deleting: %v\n", folderName+"/"+f.Name())
+			os.Remove(folderName + "/" + f.Name())
+		}
 	}
 }
 
@@ -1,12 +1,12 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"image"
-	"image/jpeg"
-	"image/png"
+	"io/ioutil"
 	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/zzwx/gamutmask/lib"
@@ -14,118 +14,145 @@ import (
 	"runtime"
 
 	"strconv"
+	"sync"
 
 	"gopkg.in/cheggaaa/pb.v1"
 )
 
-type RunGamutSettings struct {
-	Width    int
-	Height   int
-	PaddingX int
-	PaddingY int
-}
-
-var DefaultRunGamutSettings = RunGamutSettings{250, 250, 2, 2}
+// barStageSteps is the amount of progress bar steps every stage of lib.Render takes
+const barStageSteps = 4
 
-// RunGamutFuncGen generates a function that satisfies requirement of returned function signature while keeping reference
-// of the settings and using it during actual call of the RunGamutFunc which requres settings
-func RunGamutFuncGen(settings *RunGamutSettings) func(inputFileName string, outputFileName string) (exitCode int, err error) {
+// RunGamutFuncGen generates a function that satisfies requirement of processFileFunc of lib.ProcessChangedFilesOnly.
+// It renders every file with lib.RenderFile showing a progress bar and the time it took.
+// Rendering stops once ctx is done.
+func RunGamutFuncGen(ctx context.Context, settings *lib.RunGamutSettings) func(inputFileName string, outputFileName string) (exitCode int, err error) {
 	if settings == nil {
-		settings = &DefaultRunGamutSettings
+		settings = &lib.DefaultRunGamutSettings
 	}
 	return func(inputFileName string, outputFileName string) (exitCode int, err error) {
-		return RunGamutFunc(inputFileName, outputFileName, settings)
-	}
-}
-
-func ensureDir(dir string) error {
-	err := os.Mkdir(dir, 0700)
-	if err != nil {
-		if !os.IsExist(err) { // We skip already existing dir error
-			return fmt.Errorf("error creating directory: %w", err)
-		}
+		return RunGamutFunc(ctx, inputFileName, outputFileName, settings)
 	}
-	return nil
 }
 
-// RunGamutFunc will execute GenerateGamutMask against inputFileName and generate outputFileName
-// The file generated is going to be PNG so the outputFileName by convention
-// is going to be <inputFileNameWithExtention>.png
-func RunGamutFunc(inputFileName string, outputFileName string, settings *RunGamutSettings) (exitCode int, err error) {
+// RunGamutFunc will execute lib.RenderFile against inputFileName and generate outputFileName
+// while showing the progress in the console
+func RunGamutFunc(ctx context.Context, inputFileName string, outputFileName string, settings *lib.RunGamutSettings) (exitCode int, err error) {
 	if settings == nil {
-		settings = &DefaultRunGamutSettings
+		settings = &lib.DefaultRunGamutSettings
 	}
-	bar := newBar(4)
-
-	exitCode = 0
-
-	f, err := os.Open(inputFileName)
-	if err != nil {
-		return exitCode, nil // skip non-existing file
+	if _, err := os.Stat(inputFileName); os.IsNotExist(err) {
+		return 0, nil // skip non-existing file
+	}
+	if ctx.Err() != nil {
+		return 1, ctx.Err() // don't even start once stopped
 	}
-	defer f.Close()
 
 	start := time.Now()
 	fmt.Printf("Generating: %v\n", inputFileName)
 
+	bar := newBar(int(lib.StageCount) * barStageSteps)
 	bar.Start()
-	bar.Increment()
-	bar.Update()
-
-	img, err := Decode(f)
-
-	if err != nil {
-		return 1, fmt.Errorf("image couldn't be read: %w", err)
+	withBar := *settings
+	lastStep := -1
+	withBar.Progress = func(stage lib.Stage, done float64) {
+		if step := int((float64(stage) + done) * barStageSteps); step != lastStep {
+			lastStep = step
+			bar.Set(step)
+			bar.Update()
+		}
 	}
 
-	bar.Increment()
-	bar.Update()
-
-	wheel := lib.GenerateGamutMask(img, settings.Width, settings.Height, settings.PaddingX, settings.PaddingY)
-	bar.Increment()
-	bar.Update()
-
-	// Making sure directory exists
-	if err := ensureDir(filepath.Dir(outputFileName)); err != nil {
-		fmt.Errorf("error ensuring directory exists: %w", err)
+	info, err := lib.RenderFile(ctx, inputFileName, outputFileName, &withBar)
+	eraseLine()
+	var skip *lib.SkipError
+	if errors.As(err, &skip) {
+		fmt.Printf("  Skipped: %v\n", skip.Err)
+		return 1, err
 	}
-
-	out, err := os.Create(outputFileName)
 	if err != nil {
-		fmt.Errorf("error creating output file: %w", err)
+		fmt.Printf("  Error: %v\n", err)
+		return 1, err
 	}
-	bar.Increment()
-	bar.Update()
-
-	// Always using png to encode
-	png.Encode(out, wheel)
 
-	eraseLine()
-	fmt.Printf("  %8.2fs (%vpx)\n", time.Since(start).Seconds(),
-		comma(strconv.Itoa(img.Bounds().Dx()*img.Bounds().Dy())))
+	fmt.Printf("  %v\n", summary(info, time.Since(start)))
+	if err := saveMunsell(outputFileName, info.Munsell); err != nil {
+		fmt.Printf("  Error: %v\n", err)
+		return 1, err
+	}
 
 	return 0, nil
 }
 
-// Decode wraps logic of different images types into one function
-func Decode(f *os.File) (image.Image, error) {
-	var img image.Image
-	var err error
-	switch filepath.Ext(f.Name()) {
-	case ".jpg", ".jpeg":
-		img, err = jpeg.Decode(f)
-		if err != nil {
-			return nil, fmt.Errorf("can't decode image: %w", err)
+// RunGamutFuncGenConcurrent is RunGamutFuncGen for files processed concurrently.
+// Instead of progress bars, it prints a single line for every file once it is done.
+func RunGamutFuncGenConcurrent(ctx context.Context, settings *lib.RunGamutSettings) func(inputFileName string, outputFileName string) (exitCode int, err error) {
+	if settings == nil {
+		settings = &lib.DefaultRunGamutSettings
+	}
+	var output sync.Mutex
+	return func(inputFileName string, outputFileName string) (exitCode int, err error) {
+		if _, err := os.Stat(inputFileName); os.IsNotExist(err) {
+			return 0, nil // skip non-existing file
+		}
+		if ctx.Err() != nil {
+			return 1, ctx.Err() // don't even start once stopped
+		}
+
+		start := time.Now()
+		info, err := lib.RenderFile(ctx, inputFileName, outputFileName, settings)
+
+		output.Lock()
+		defer output.Unlock()
+		var skip *lib.SkipError
+		if errors.As(err, &skip) {
+			fmt.Printf("Skipped: %v: %v\n", inputFileName, skip.Err)
+			return 1, err
 		}
-	case ".png":
-		img, err = png.Decode(f)
 		if err != nil {
-			return nil, fmt.Errorf("can't decode image: %w", err)
+			fmt.Printf("Error: %v: %v\n", inputFileName, err)
+			return 1, err
+		}
+		fmt.Printf("Generated: %v  %v\n", inputFileName, summary(info, time.Since(start)))
+		if err := saveMunsell(outputFileName, info.Munsell); err != nil {
+			fmt.Printf("Error: %v: %v\n", inputFileName, err)
+			return 1, err
 		}
-	default:
-		return nil, fmt.Errorf("unsupported image type: %v", filepath.Ext(f.Name()))
+		return 0, nil
+	}
+}
+
+// summary describes the rendered image and the time it took
+func summary(info lib.RenderInfo, elapsed time.Duration) string {
+	details := ""
+	if !info.Sampling.All(info.Bounds) {
+		details = ", sampled " + info.Sampling.String()
+	}
+	if info.Cached {
+		details = ", cached"
+	}
+	if info.Munsell != nil {
+		details += ", munsell " + info.Munsell.Dominant.String()
 	}
-	return img, nil
+	return fmt.Sprintf("%8.2fs (%vpx%v)", elapsed.Seconds(),
+		comma(strconv.Itoa(info.Bounds.Dx()*info.Bounds.Dy())), details)
+}
+
+// munsellExt is appended to the names of gamut images for the Munsell summaries written next to them
+const munsellExt = ".munsell.json"
+
+// saveMunsell writes the Munsell summary of the image rendered into outputFileName, if any
+func saveMunsell(outputFileName string, summary *lib.MunsellSummary) error {
+	if summary == nil {
+		return nil
+	}
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("can't encode munsell summary: %w", err)
+	}
+	if err := ioutil.WriteFile(outputFileName+munsellExt, data, 0644); err != nil {
+		return fmt.Errorf("can't write munsell summary: %w", err)
+	}
+	return nil
 }
 
 func eraseLine() {
@@ -147,7 +174,7 @@ func newBar(count int) (bar *pb.ProgressBar) {
 	bar.ForceWidth = false
 	bar.Format("│\x00▒\x00▒\x00░\x00│")
 	bar.ForceWidth = true
-	bar.Width = count*2 + 2
+	bar.Width = count + 2
 	return
 }
 